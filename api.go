@@ -116,6 +116,43 @@ func (r responseAddressesAddr) MarshalJSON() ([]byte, error) {
 	}{encodedUnlockConditions(r.UnlockConditions), r.KeyIndex})
 }
 
+// RequestAddresses is the request type for the POST /addresses endpoint.
+type RequestAddresses struct {
+	wallet.SeedAddressInfo
+	AddressMetadata
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r RequestAddresses) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		UnlockConditions encodedUnlockConditions `json:"unlockConditions"`
+		KeyIndex         uint64                  `json:"keyIndex"`
+		Label            string                  `json:"label,omitempty"`
+		Tags             []string                `json:"tags,omitempty"`
+		Metadata         json.RawMessage         `json:"metadata,omitempty"`
+	}{encodedUnlockConditions(r.UnlockConditions), r.KeyIndex, r.Label, r.Tags, r.Metadata})
+}
+
+// ResponseAddress is the response type for the /addresses/:addr endpoint, and
+// for the /addresses endpoint when full=true.
+type ResponseAddress struct {
+	Address types.UnlockHash `json:"address"`
+	wallet.SeedAddressInfo
+	AddressMetadata
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r ResponseAddress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Address          types.UnlockHash        `json:"address"`
+		UnlockConditions encodedUnlockConditions `json:"unlockConditions"`
+		KeyIndex         uint64                  `json:"keyIndex"`
+		Label            string                  `json:"label,omitempty"`
+		Tags             []string                `json:"tags,omitempty"`
+		Metadata         json.RawMessage         `json:"metadata,omitempty"`
+	}{r.Address, encodedUnlockConditions(r.UnlockConditions), r.KeyIndex, r.Label, r.Tags, r.Metadata})
+}
+
 type responseBlockRewards []wallet.BlockReward
 
 func (r responseBlockRewards) MarshalJSON() ([]byte, error) {
@@ -140,7 +177,62 @@ type ResponseConsensus struct {
 	CCID   crypto.Hash       `json:"ccid"`
 }
 
-type responseLimbo []wallet.LimboTransaction
+// A FeeTier is a transaction fee priority.
+type FeeTier string
+
+// Fee tiers.
+const (
+	// FeeEconomy is the minimum fee recommended by the transaction pool.
+	FeeEconomy FeeTier = "economy"
+	// FeeNormal is halfway between FeeEconomy and FeeUrgent.
+	FeeNormal FeeTier = "normal"
+	// FeeUrgent is the maximum fee recommended by the transaction pool.
+	FeeUrgent FeeTier = "urgent"
+)
+
+// ResponseFee is the response type for the /fee endpoint when full=true. All
+// values are in hastings per byte.
+type ResponseFee struct {
+	Min     types.Currency `json:"min"`
+	Max     types.Currency `json:"max"`
+	Economy types.Currency `json:"economy"`
+	Normal  types.Currency `json:"normal"`
+	Urgent  types.Currency `json:"urgent"`
+}
+
+// Tier returns the fee rate for the specified tier.
+func (r ResponseFee) Tier(tier FeeTier) (types.Currency, bool) {
+	switch tier {
+	case FeeEconomy:
+		return r.Economy, true
+	case FeeNormal:
+		return r.Normal, true
+	case FeeUrgent:
+		return r.Urgent, true
+	}
+	return types.ZeroCurrency, false
+}
+
+// ResponseTxnFee is the response type for the /txn/fee endpoint.
+type ResponseTxnFee struct {
+	// Size is the encoded size of the transaction, in bytes, after any
+	// missing signatures are supplied.
+	Size uint64 `json:"size"`
+	// MinerFees is the sum of the transaction's current miner fees.
+	MinerFees types.Currency `json:"minerFees"`
+	// Economy, Normal, and Urgent are the total fees required at each tier.
+	Economy types.Currency `json:"economy"`
+	Normal  types.Currency `json:"normal"`
+	Urgent  types.Currency `json:"urgent"`
+}
+
+type limboEntry struct {
+	wallet.LimboTransaction
+	ConflictingID *types.TransactionID
+	Rebroadcast   *RebroadcastStatus
+}
+
+type responseLimbo []limboEntry
 
 func (r responseLimbo) MarshalJSON() ([]byte, error) {
 	// NOTE: normally we would simply embed the JSONTransaction field, but doing
@@ -152,9 +244,11 @@ func (r responseLimbo) MarshalJSON() ([]byte, error) {
 	for i := range enc {
 		js1, _ := json.Marshal(JSONTransaction(r[i].Transaction))
 		js2, _ := json.Marshal(struct {
-			ID         string    `json:"id"`
-			LimboSince time.Time `json:"limboSince"`
-		}{r[i].ID().String(), r[i].LimboSince})
+			ID            string               `json:"id"`
+			LimboSince    time.Time            `json:"limboSince"`
+			ConflictingID *types.TransactionID `json:"conflictingID,omitempty"`
+			Rebroadcast   *RebroadcastStatus   `json:"rebroadcast,omitempty"`
+		}{r[i].ID().String(), r[i].LimboSince, r[i].ConflictingID, r[i].Rebroadcast})
 		js2[0] = ','
 		enc[i] = append(js1[:len(js1)-1], js2...)
 	}
@@ -197,29 +291,58 @@ func (r responseFileContracts) MarshalJSON() ([]byte, error) {
 	return json.Marshal(enc)
 }
 
+// A TransactionStatus describes the state of a transaction.
+type TransactionStatus string
+
+// Transaction statuses.
+const (
+	// StatusLimbo indicates that the transaction has been broadcast (or
+	// manually added to Limbo), but has not yet appeared in a block.
+	StatusLimbo TransactionStatus = "limbo"
+	// StatusConfirmed indicates that the transaction appears in a block.
+	StatusConfirmed TransactionStatus = "confirmed"
+	// StatusReverted indicates that the transaction appeared in a block that
+	// was subsequently reverted, and has not reappeared in a block since.
+	StatusReverted TransactionStatus = "reverted"
+)
+
 // ResponseTransactionsID is the response type for the /transactions/:id
 // endpoint.
 type ResponseTransactionsID struct {
-	Transaction types.Transaction `json:"transaction"`
-	BlockID     types.BlockID     `json:"blockID"`
-	BlockHeight types.BlockHeight `json:"blockHeight"`
-	Timestamp   time.Time         `json:"timestamp"`
-	FeePerByte  types.Currency    `json:"feePerByte"`
-	Credit      types.Currency    `json:"credit"`
-	Debit       types.Currency    `json:"debit"`
+	Transaction   types.Transaction `json:"transaction"`
+	BlockID       types.BlockID     `json:"blockID"`
+	BlockHeight   types.BlockHeight `json:"blockHeight"`
+	Timestamp     time.Time         `json:"timestamp"`
+	FeePerByte    types.Currency    `json:"feePerByte"`
+	Credit        types.Currency    `json:"credit"`
+	Debit         types.Currency    `json:"debit"`
+	Fee           types.Currency    `json:"fee"`
+	Confirmations uint64            `json:"confirmations"`
+	Status        TransactionStatus `json:"status"`
+	// Memo is the transaction's memo, if it is text. Binary memos are
+	// stored in MemoData instead.
+	Memo     string `json:"memo"`
+	MemoData []byte `json:"memoData,omitempty"`
+	MemoType string `json:"memoType,omitempty"`
 }
 
 // MarshalJSON implements json.Marshaler.
 func (r ResponseTransactionsID) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Transaction JSONTransaction   `json:"transaction"`
-		BlockID     types.BlockID     `json:"blockID"`
-		BlockHeight types.BlockHeight `json:"blockHeight"`
-		Timestamp   time.Time         `json:"timestamp"`
-		FeePerByte  types.Currency    `json:"feePerByte"`
-		Credit      types.Currency    `json:"credit"`
-		Debit       types.Currency    `json:"debit"`
-	}{JSONTransaction(r.Transaction), r.BlockID, r.BlockHeight, r.Timestamp, r.FeePerByte, r.Credit, r.Debit})
+		Transaction   JSONTransaction   `json:"transaction"`
+		BlockID       types.BlockID     `json:"blockID"`
+		BlockHeight   types.BlockHeight `json:"blockHeight"`
+		Timestamp     time.Time         `json:"timestamp"`
+		FeePerByte    types.Currency    `json:"feePerByte"`
+		Credit        types.Currency    `json:"credit"`
+		Debit         types.Currency    `json:"debit"`
+		Fee           types.Currency    `json:"fee"`
+		Confirmations uint64            `json:"confirmations"`
+		Status        TransactionStatus `json:"status"`
+		Memo          string            `json:"memo"`
+		MemoData      []byte            `json:"memoData,omitempty"`
+		MemoType      string            `json:"memoType,omitempty"`
+	}{JSONTransaction(r.Transaction), r.BlockID, r.BlockHeight, r.Timestamp, r.FeePerByte, r.Credit, r.Debit, r.Fee, r.Confirmations, r.Status, r.Memo, r.MemoData, r.MemoType})
 }
 
 type responseBatchqueryAddresses map[types.UnlockHash]wallet.SeedAddressInfo
@@ -271,3 +394,159 @@ func (r *responseBatchqueryTransactions) UnmarshalJSON(b []byte) error {
 	}
 	return err
 }
+
+// CoinControl restricts the outputs that may be spent when funding a
+// transaction.
+type CoinControl struct {
+	// Include lists outputs that must be spent. Additional outputs are spent
+	// only if the included outputs are insufficient, and IncludeOnly is not
+	// set.
+	Include []types.SiacoinOutputID `json:"include,omitempty"`
+	// IncludeOnly prevents any outputs other than those in Include from being
+	// spent.
+	IncludeOnly bool `json:"includeOnly,omitempty"`
+	// Exclude lists outputs that must not be spent.
+	Exclude []types.SiacoinOutputID `json:"exclude,omitempty"`
+}
+
+// RequestTxnFund is the request type for the /txn/fund endpoint.
+type RequestTxnFund struct {
+	CoinControl
+
+	// Outputs are the desired outputs of the transaction.
+	Outputs []types.SiacoinOutput `json:"outputs"`
+	// FeePerByte is the fee rate, in hastings per byte of the encoded
+	// transaction. If zero, the rate returned by /fee is used.
+	FeePerByte types.Currency `json:"feePerByte"`
+	// ChangeAddress receives any excess value. If nil, the address of the
+	// first input is used.
+	ChangeAddress *types.UnlockHash `json:"changeAddress,omitempty"`
+	// Reserve, if non-zero, is the number of seconds for which the selected
+	// inputs are reserved. See /reservations.
+	Reserve uint64 `json:"reserve,omitempty"`
+}
+
+// RequestTxnConsolidate is the request type for the /txn/consolidate
+// endpoint.
+type RequestTxnConsolidate struct {
+	// Address receives the consolidated outputs.
+	Address types.UnlockHash `json:"address"`
+	// Addresses, if non-empty, restricts consolidation to outputs sent to
+	// these addresses.
+	Addresses []types.UnlockHash `json:"addresses,omitempty"`
+	// MaxValue, if non-zero, restricts consolidation to outputs worth at most
+	// this amount.
+	MaxValue types.Currency `json:"maxValue"`
+	// MaxSize is the maximum encoded size of each transaction, in bytes. If
+	// zero, the transaction pool's size limit is used.
+	MaxSize uint64 `json:"maxSize,omitempty"`
+	// FeePerByte is the fee rate, in hastings per byte of the encoded
+	// transaction. If zero, the rate returned by /fee is used. It may not
+	// exceed the maximum rate reported by the transaction pool.
+	FeePerByte types.Currency `json:"feePerByte"`
+	// Reserve, if non-zero, is the number of seconds for which the
+	// consolidated inputs are reserved. See /reservations.
+	Reserve uint64 `json:"reserve,omitempty"`
+}
+
+// RequestTxnSweep is the request type for the /txn/sweep endpoint.
+type RequestTxnSweep struct {
+	CoinControl
+
+	// Address receives the swept value.
+	Address types.UnlockHash `json:"address"`
+	// Addresses, if non-empty, restricts the sweep to outputs sent to these
+	// addresses.
+	Addresses []types.UnlockHash `json:"addresses,omitempty"`
+	// FeePerByte is the fee rate, in hastings per byte of the encoded
+	// transaction. If zero, the rate returned by /fee is used.
+	FeePerByte types.Currency `json:"feePerByte"`
+	// Reserve, if non-zero, is the number of seconds for which the swept
+	// inputs are reserved. See /reservations.
+	Reserve uint64 `json:"reserve,omitempty"`
+}
+
+// A DiagnosticSeverity indicates whether a Diagnostic prevents a transaction
+// from being valid.
+type DiagnosticSeverity string
+
+// Diagnostic severities.
+const (
+	// SeverityError indicates that the transaction set is invalid.
+	SeverityError DiagnosticSeverity = "error"
+	// SeverityWarning indicates a potential problem that does not by itself
+	// make the transaction set invalid.
+	SeverityWarning DiagnosticSeverity = "warning"
+)
+
+// A Diagnostic describes a problem with a transaction or transaction set.
+type Diagnostic struct {
+	Severity DiagnosticSeverity `json:"severity"`
+	Code     string             `json:"code"`
+	Message  string             `json:"message"`
+	// Input is the index of the relevant SiacoinInput, if any.
+	Input *int `json:"input,omitempty"`
+}
+
+// TransactionDiagnostics describes the validity of a single transaction.
+type TransactionDiagnostics struct {
+	ID          types.TransactionID `json:"id"`
+	Valid       bool                `json:"valid"`
+	Size        uint64              `json:"size"`
+	Fee         types.Currency      `json:"fee"`
+	MinFee      types.Currency      `json:"minFee"`
+	Diagnostics []Diagnostic        `json:"diagnostics"`
+}
+
+// ResponseValidate is the response type for the /validate endpoint.
+type ResponseValidate struct {
+	Valid        bool                     `json:"valid"`
+	Fee          types.Currency           `json:"fee"`
+	MinFee       types.Currency           `json:"minFee"`
+	Diagnostics  []Diagnostic             `json:"diagnostics"`
+	Transactions []TransactionDiagnostics `json:"transactions"`
+}
+
+// RequestReservations is the request type for the POST /reservations endpoint.
+type RequestReservations struct {
+	IDs []types.SiacoinOutputID `json:"ids"`
+	// Duration is the number of seconds for which the outputs are reserved.
+	// It may not exceed MaxReservationDuration.
+	Duration uint64 `json:"duration"`
+}
+
+// A RequiredSignature identifies a signature that must be supplied before a
+// funded transaction can be broadcast.
+type RequiredSignature struct {
+	// ParentID is the ID of the input being signed.
+	ParentID crypto.Hash `json:"parentID"`
+	// SigIndex is the index of the signature in the transaction's
+	// TransactionSignatures.
+	SigIndex int `json:"sigIndex"`
+	// KeyIndex is the seed index of the key that must produce the signature.
+	KeyIndex uint64 `json:"keyIndex"`
+	// SigHash is the hash that must be signed.
+	SigHash crypto.Hash `json:"sigHash"`
+}
+
+// ResponseTxnFund is the response type for the /txn/fund endpoint.
+type ResponseTxnFund struct {
+	Transaction types.Transaction   `json:"transaction"`
+	ToSign      []RequiredSignature `json:"toSign"`
+	Fee         types.Currency      `json:"fee"`
+	Change      types.Currency      `json:"change"`
+	// ReservationToken, if non-empty, is the token of the reservation on the
+	// transaction's inputs, which is required to release it.
+	ReservationToken string `json:"reservationToken,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r ResponseTxnFund) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Transaction      JSONTransaction     `json:"transaction"`
+		ToSign           []RequiredSignature `json:"toSign"`
+		Fee              types.Currency      `json:"fee"`
+		Change           types.Currency      `json:"change"`
+		ReservationToken string              `json:"reservationToken,omitempty"`
+	}{JSONTransaction(r.Transaction), r.ToSign, r.Fee, r.Change, r.ReservationToken})
+}
@@ -0,0 +1,149 @@
+package walrus
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// A Scope is a class of API routes that a credential is permitted to access.
+type Scope string
+
+// API scopes.
+const (
+	// ScopeRead grants access to routes that do not modify the wallet, e.g.
+	// GET /balance.
+	ScopeRead Scope = "read"
+	// ScopeBroadcast grants access to routes that broadcast transactions,
+	// modify Limbo, or reserve outputs.
+	ScopeBroadcast Scope = "broadcast"
+	// ScopeAddresses grants access to routes that add or remove addresses.
+	ScopeAddresses Scope = "addresses"
+	// ScopeMemos grants access to routes that modify transaction memos.
+	ScopeMemos Scope = "memos"
+)
+
+// AllScopes contains every Scope.
+var AllScopes = []Scope{ScopeRead, ScopeBroadcast, ScopeAddresses, ScopeMemos}
+
+// ParseScopes parses a comma-separated list of scopes, e.g. "read,broadcast".
+// The special value "all" expands to AllScopes.
+func ParseScopes(s string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			scopes = append(scopes, AllScopes...)
+			continue
+		}
+		valid := false
+		for _, scope := range AllScopes {
+			valid = valid || Scope(name) == scope
+		}
+		if !valid {
+			return nil, fmt.Errorf("unrecognized scope %q", name)
+		}
+		scopes = append(scopes, Scope(name))
+	}
+	return scopes, nil
+}
+
+type credential struct {
+	secret string
+	bearer bool
+	scopes map[Scope]struct{}
+}
+
+func newCredential(secret string, bearer bool, scopes []Scope) credential {
+	if len(scopes) == 0 {
+		scopes = AllScopes
+	}
+	c := credential{
+		secret: secret,
+		bearer: bearer,
+		scopes: make(map[Scope]struct{}, len(scopes)),
+	}
+	for _, scope := range scopes {
+		c.scopes[scope] = struct{}{}
+	}
+	return c
+}
+
+// AuthPassword adds a password credential to the server. Clients supply the
+// password via HTTP Basic Authentication; the username is ignored. The
+// credential grants access to the specified scopes, or to all scopes if none
+// are specified.
+//
+// If no credentials are added, the API is unauthenticated.
+func AuthPassword(password string, scopes ...Scope) ServerOption {
+	return func(s *server) {
+		s.creds = append(s.creds, newCredential(password, false, scopes))
+	}
+}
+
+// AuthToken adds a bearer token credential to the server. Clients supply the
+// token via an "Authorization: Bearer <token>" header. The credential grants
+// access to the specified scopes, or to all scopes if none are specified.
+//
+// If no credentials are added, the API is unauthenticated.
+func AuthToken(token string, scopes ...Scope) ServerOption {
+	return func(s *server) {
+		s.creds = append(s.creds, newCredential(token, true, scopes))
+	}
+}
+
+// authenticate returns the union of the scopes granted by any credentials
+// supplied with req.
+func (s *server) authenticate(req *http.Request) (map[Scope]struct{}, bool) {
+	var secret string
+	var bearer bool
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		secret, bearer = strings.TrimPrefix(auth, "Bearer "), true
+	} else if _, password, ok := req.BasicAuth(); ok {
+		secret = password
+	} else {
+		return nil, false
+	}
+	scopes := make(map[Scope]struct{})
+	for _, c := range s.creds {
+		// NOTE: compare against every credential, even after a match, so that
+		// timing does not reveal which credential matched
+		if c.bearer == bearer && subtle.ConstantTimeCompare([]byte(c.secret), []byte(secret)) == 1 {
+			for scope := range c.scopes {
+				scopes[scope] = struct{}{}
+			}
+		}
+	}
+	return scopes, len(scopes) > 0
+}
+
+// checkScope writes an error to w and returns false if req lacks a credential
+// granting the specified scope.
+func (s *server) checkScope(w http.ResponseWriter, req *http.Request, scope Scope) bool {
+	if len(s.creds) == 0 {
+		return true
+	}
+	scopes, ok := s.authenticate(req)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="walrus"`)
+		writeError(w, http.StatusUnauthorized, CodeUnauthorized, "API authentication failed")
+		return false
+	} else if _, ok := scopes[scope]; !ok {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Credential does not grant the '"+string(scope)+"' scope")
+		return false
+	}
+	return true
+}
+
+// authorize wraps h, rejecting requests that lack a credential granting the
+// specified scope.
+func (s *server) authorize(scope Scope, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		if s.checkScope(w, req, scope) {
+			h(w, req, ps)
+		}
+	}
+}
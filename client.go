@@ -1,57 +1,167 @@
 package walrus
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"reflect"
-	"sort"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.sia.tech/siad/crypto"
 	"go.sia.tech/siad/types"
-	"lukechampine.com/frand"
 	"lukechampine.com/us/ed25519hash"
 	"lukechampine.com/us/renter/proto"
 	"lukechampine.com/us/wallet"
 )
 
-// A Client communicates with a walrus server.
+// A Client communicates with one or more walrus servers.
 type Client struct {
-	addr string
+	eps      *endpointSet
+	hc       *http.Client
+	ctx      context.Context
+	header   http.Header
+	password string
+	token    string
+
+	retries        int
+	backoff        time.Duration
+	checkConsensus bool
+}
+
+// A ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithPassword configures the Client to authenticate with the supplied API
+// password via HTTP Basic Authentication.
+func WithPassword(password string) ClientOption {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithToken configures the Client to authenticate with the supplied bearer
+// token. If both a token and a password are supplied, the token is used.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient configures the Client to use hc for all requests. Options
+// that modify the underlying http.Client, such as WithTimeout, must be supplied
+// after WithHTTPClient.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithTransport configures the Client to use rt when making requests.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.modifyHTTPClient(func(hc *http.Client) { hc.Transport = rt })
+	}
+}
+
+// WithTimeout configures the Client to abort any request that takes longer
+// than d, including reading the response body. Note that this also applies to
+// event subscriptions; to limit individual calls, use WithContext instead.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.modifyHTTPClient(func(hc *http.Client) { hc.Timeout = d })
+	}
+}
+
+// WithHeader configures the Client to add the specified header to every
+// request. Headers set by the Client itself, such as Authorization, take
+// precedence.
+func WithHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// modifyHTTPClient applies fn to a copy of c's http.Client, so that shared
+// clients (e.g. http.DefaultClient) are never modified.
+func (c *Client) modifyHTTPClient(fn func(*http.Client)) {
+	hc := *c.hc
+	fn(&hc)
+	c.hc = &hc
+}
+
+// WithContext returns a shallow copy of c that uses ctx for all requests. If
+// ctx is canceled or its deadline expires, any in-flight request is aborted,
+// and the method returns ctx.Err() (possibly wrapped).
+//
+// For example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	bal, err := c.WithContext(ctx).Balance(false)
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+func (c *Client) setAuth(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.password != "" {
+		req.SetBasicAuth("", c.password)
+	}
 }
 
 func (c *Client) req(method string, route string, data, resp interface{}) error {
-	var body io.Reader
+	_, err := c.reqHeader(method, route, data, resp)
+	return err
+}
+
+// reqHeader is like req, but also returns the response headers.
+func (c *Client) reqHeader(method string, route string, data, resp interface{}) (http.Header, error) {
+	h, _, err := c.reqEndpoint(method, route, data, resp)
+	return h, err
+}
+
+// reqEndpoint is like reqHeader, but also returns the endpoint that served the
+// request.
+func (c *Client) reqEndpoint(method string, route string, data, resp interface{}) (http.Header, string, error) {
+	var body []byte
 	if data != nil {
-		js, _ := json.Marshal(data)
-		body = bytes.NewReader(js)
-	}
-	req, err := http.NewRequest(method, fmt.Sprintf("%v%v", c.addr, route), body)
-	if err != nil {
-		panic(err)
+		body, _ = json.Marshal(data)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	r, err := http.DefaultClient.Do(req)
+	r, addr, err := c.doEndpoints(method, route, body, http.Header{"Content-Type": {"application/json"}})
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 	defer io.Copy(ioutil.Discard, r.Body)
 	defer r.Body.Close()
 	if r.StatusCode != 200 {
-		err, _ := ioutil.ReadAll(r.Body)
-		return errors.New(string(err))
+		return nil, addr, responseError(r)
 	}
 	if resp == nil {
-		return nil
+		return r.Header, addr, nil
 	}
-	return json.NewDecoder(r.Body).Decode(resp)
+	return r.Header, addr, json.NewDecoder(r.Body).Decode(resp)
+}
+
+// responseError returns the *Error contained in the body of r, which must have
+// a non-200 status.
+func responseError(r *http.Response) error {
+	body, _ := ioutil.ReadAll(r.Body)
+	return decodeError(r.StatusCode, body)
 }
 
 func (c *Client) get(route string, r interface{}) error     { return c.req("GET", route, nil, r) }
@@ -59,6 +169,13 @@ func (c *Client) post(route string, d, r interface{}) error { return c.req("POST
 func (c *Client) put(route string, d interface{}) error     { return c.req("PUT", route, d, nil) }
 func (c *Client) delete(route string) error                 { return c.req("DELETE", route, nil, nil) }
 
+// getPage performs a GET request for a page of results, returning the cursor
+// for the next page.
+func (c *Client) getPage(route string, r interface{}) (next string, err error) {
+	h, err := c.reqHeader("GET", route, nil, r)
+	return h.Get(NextCursorHeader), err
+}
+
 // Addresses returns all addresses known to the wallet.
 func (c *Client) Addresses() (addrs []types.UnlockHash, err error) {
 	err = c.get("/addresses", &addrs)
@@ -72,11 +189,43 @@ func (c *Client) AddressInfo(addr types.UnlockHash) (info wallet.SeedAddressInfo
 	return
 }
 
+// AddressesWithMetadata returns information about each address known to the
+// wallet, including its metadata. If tags are supplied, only addresses with all
+// of the specified tags are returned.
+func (c *Client) AddressesWithMetadata(tags ...string) (addrs []ResponseAddress, err error) {
+	v := url.Values{"full": {"true"}, "tag": tags}
+	err = c.get("/addresses?"+v.Encode(), &addrs)
+	return
+}
+
+// AddressesByTag returns the addresses with all of the specified tags.
+func (c *Client) AddressesByTag(tags ...string) (addrs []types.UnlockHash, err error) {
+	err = c.get("/addresses?"+url.Values{"tag": tags}.Encode(), &addrs)
+	return
+}
+
+// AddressMetadata returns the metadata attached to an address.
+func (c *Client) AddressMetadata(addr types.UnlockHash) (md AddressMetadata, err error) {
+	var resp ResponseAddress
+	err = c.get("/addresses/"+addr.String(), &resp)
+	return resp.AddressMetadata, err
+}
+
+// SetAddressMetadata attaches metadata to an address, replacing any existing
+// metadata. The address must be known to the wallet.
+func (c *Client) SetAddressMetadata(addr types.UnlockHash, md AddressMetadata) error {
+	return c.put("/addresses/"+addr.String(), md)
+}
+
 // Balance returns the current wallet balance. If the limbo flag is true, the
 // balance will reflect any transactions currently in Limbo.
 func (c *Client) Balance(limbo bool) (bal types.Currency, err error) {
-	err = c.get("/balance?limbo="+strconv.FormatBool(limbo), &bal)
-	return
+	return c.balance("/balance?limbo=" + strconv.FormatBool(limbo))
+}
+
+// UnreservedBalance is like Balance, but excludes reserved outputs.
+func (c *Client) UnreservedBalance(limbo bool) (bal types.Currency, err error) {
+	return c.balance("/balance?limbo=" + strconv.FormatBool(limbo) + "&excludeReserved=true")
 }
 
 // BatchAddresses returns information about a set of addresses, including their
@@ -110,6 +259,50 @@ func (c *Client) BlockRewards(max int) (rewards []wallet.BlockReward, err error)
 	return
 }
 
+// Subscribe opens a stream of events from the server. If no types are
+// specified, events of every type are delivered. The server must have been
+// created with the Events option.
+func (c *Client) Subscribe(types ...EventType) (*Subscription, error) {
+	route := "/events"
+	if len(types) > 0 {
+		strs := make([]string, len(types))
+		for i := range types {
+			strs[i] = string(types[i])
+		}
+		route += "?types=" + strings.Join(strs, ",")
+	}
+	r, err := c.do("GET", route, nil, http.Header{"Accept": {"text/event-stream"}})
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		defer r.Body.Close()
+		return nil, responseError(r)
+	}
+	sub := &Subscription{
+		ch:     make(chan Event),
+		body:   r.Body,
+		closed: make(chan struct{}),
+	}
+	go sub.run()
+	return sub, nil
+}
+
+// BlockRewardsPage returns up to limit block rewards following cursor, along
+// with the cursor for the next page. If cursor is empty, the page begins with
+// the newest reward; if limit is negative, all remaining rewards are returned.
+// When no rewards remain, the returned cursor is empty.
+func (c *Client) BlockRewardsPage(cursor string, limit int) (rewards []wallet.BlockReward, next string, err error) {
+	next, err = c.getPage("/blockrewards?"+pageQuery(cursor, limit).Encode(), &rewards)
+	return
+}
+
+// BlockRewardIterator returns an iterator over the wallet's block rewards,
+// fetching pageSize rewards at a time.
+func (c *Client) BlockRewardIterator(pageSize int) *BlockRewardIterator {
+	return &BlockRewardIterator{c: c, pageSize: pageSize}
+}
+
 // ConsensusInfo returns the current blockchain height and consensus change ID.
 // The latter is a unique ID that changes whenever blocks are added to the
 // blockchain.
@@ -125,6 +318,13 @@ func (c *Client) RecommendedFee() (fee types.Currency, err error) {
 	return
 }
 
+// Fees returns the current fee rate of each fee tier, along with the minimum
+// and maximum fees recommended by the transaction pool.
+func (c *Client) Fees() (fees ResponseFee, err error) {
+	err = c.get("/fee?full=true", &fees)
+	return
+}
+
 // FileContracts returns the file contracts tracked by the wallet. If max < 0,
 // all contracts are returned; otherwise, at most max contracts are returned.
 // The contracts are ordered newest-to-oldest.
@@ -133,6 +333,21 @@ func (c *Client) FileContracts(max int) (contracts []wallet.FileContract, err er
 	return
 }
 
+// FileContractsPage returns up to limit file contracts following cursor, along
+// with the cursor for the next page. If cursor is empty, the page begins with
+// the newest contract; if limit is negative, all remaining contracts are
+// returned. When no contracts remain, the returned cursor is empty.
+func (c *Client) FileContractsPage(cursor string, limit int) (contracts []wallet.FileContract, next string, err error) {
+	next, err = c.getPage("/filecontracts?"+pageQuery(cursor, limit).Encode(), &contracts)
+	return
+}
+
+// FileContractIterator returns an iterator over the wallet's file contracts,
+// fetching pageSize contracts at a time.
+func (c *Client) FileContractIterator(pageSize int) *FileContractIterator {
+	return &FileContractIterator{c: c, pageSize: pageSize}
+}
+
 // FileContractHistory returns the revision history of the specified file
 // contract, which must be a contract tracked by the wallet.
 func (c *Client) FileContractHistory(id types.FileContractID) (history []wallet.FileContract, err error) {
@@ -164,42 +379,120 @@ func (c *Client) RemoveFromLimbo(txid types.TransactionID) (err error) {
 	return c.delete("/limbo/" + txid.String())
 }
 
-// Memo retrieves the memo for a transaction.
+// Memo retrieves the memo for a transaction. If the transaction has no memo,
+// Memo returns nil.
 func (c *Client) Memo(txid types.TransactionID) (memo []byte, err error) {
-	resp, err := http.Get(fmt.Sprintf("http://%v/memos/%v", c.addr, txid.String()))
+	m, err := c.TypedMemo(txid)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return m.Data, err
+}
+
+// TypedMemo retrieves the memo for a transaction, along with its content type.
+// If the transaction has no memo, TypedMemo returns ErrNotFound.
+func (c *Client) TypedMemo(txid types.TransactionID) (memo Memo, err error) {
+	resp, err := c.do("GET", "/memos/"+txid.String(), nil, nil)
 	if err != nil {
-		return nil, err
+		return Memo{}, err
 	}
 	defer resp.Body.Close()
-	data, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return nil, errors.New(string(data))
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Memo{}, err
+	} else if resp.StatusCode != 200 {
+		return Memo{}, decodeError(resp.StatusCode, data)
 	}
-	return data, nil
+	return Memo{
+		TransactionID: txid,
+		ContentType:   resp.Header.Get("Content-Type"),
+		Data:          data,
+	}, nil
 }
 
 // SetMemo adds a memo for a transaction, overwriting the previous memo if it
-// exists.
+// exists. The content type of the memo is detected automatically. An empty
+// memo deletes the previous memo.
 //
 // Memos are not stored on the blockchain. They exist only in the local wallet.
 func (c *Client) SetMemo(txid types.TransactionID, memo []byte) (err error) {
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%v/memos/%v", c.addr, txid.String()), bytes.NewReader(memo))
-	if err != nil {
-		panic(err)
+	return c.SetTypedMemo(txid, "", memo)
+}
+
+// SetTypedMemo is like SetMemo, but stores the memo with the specified content
+// type, which must be a valid media type (e.g. "application/json").
+func (c *Client) SetTypedMemo(txid types.TransactionID, contentType string, memo []byte) (err error) {
+	var h http.Header
+	if contentType != "" {
+		h = http.Header{"Content-Type": {contentType}}
 	}
-	r, err := http.DefaultClient.Do(req)
+	r, err := c.do("PUT", "/memos/"+txid.String(), memo, h)
 	if err != nil {
 		return err
 	}
 	defer io.Copy(ioutil.Discard, r.Body)
 	defer r.Body.Close()
 	if r.StatusCode != 200 {
-		err, _ := ioutil.ReadAll(r.Body)
-		return errors.New(string(err))
+		return responseError(r)
 	}
 	return nil
 }
 
+// DeleteMemo deletes the memo for a transaction, if it exists.
+func (c *Client) DeleteMemo(txid types.TransactionID) (err error) {
+	return c.delete("/memos/" + txid.String())
+}
+
+// Memos returns all memos stored in the wallet, ordered from most- to
+// least-recently modified.
+func (c *Client) Memos() (memos []Memo, err error) {
+	err = c.get("/memos", &memos)
+	return
+}
+
+// MemosPage returns up to limit memos following cursor, along with the cursor
+// for the next page. If cursor is empty, the page begins with the most
+// recently modified memo; if limit is negative, all remaining memos are
+// returned. When no memos remain, the returned cursor is empty.
+func (c *Client) MemosPage(cursor string, limit int) (memos []Memo, next string, err error) {
+	next, err = c.getPage("/memos?"+pageQuery(cursor, limit).Encode(), &memos)
+	return
+}
+
+// A MemoQuery specifies a search of transaction memos.
+type MemoQuery struct {
+	// Text is the text to search for, ignoring case.
+	Text string
+	// Prefix restricts the results to memos that begin with Text, rather
+	// than merely containing it.
+	Prefix bool
+	// Cursor identifies the page; if empty, the page begins with the newest
+	// transaction.
+	Cursor string
+	// Limit is the maximum number of transactions returned; if zero, the
+	// server's default page size is used, and if negative, all remaining
+	// transactions are returned.
+	Limit int
+}
+
+func (q MemoQuery) values() url.Values {
+	v := pageQuery(q.Cursor, q.Limit)
+	v.Set("q", q.Text)
+	if q.Prefix {
+		v.Set("match", "prefix")
+	}
+	return v
+}
+
+// SearchMemos returns the transactions whose text memos match q, ordered
+// newest-to-oldest, along with the cursor for the next page. When no
+// transactions remain, the returned cursor is empty. Address labels are not
+// searched.
+func (c *Client) SearchMemos(q MemoQuery) (txns []ResponseTransactionsID, next string, err error) {
+	next, err = c.getPage("/memosearch?"+q.values().Encode(), &txns)
+	return
+}
+
 // SeedIndex returns the index that should be used to derive the next address.
 func (c *Client) SeedIndex() (index uint64, err error) {
 	err = c.get("/seedindex", &index)
@@ -214,6 +507,97 @@ func (c *Client) Transactions(max int) (txids []types.TransactionID, err error)
 	return
 }
 
+// A TransactionQuery specifies a page of transaction history.
+type TransactionQuery struct {
+	// Cursor identifies the page; if empty, the page begins with the newest
+	// transaction.
+	Cursor string
+	// Limit is the maximum number of transactions returned; if zero, the
+	// server's default page size is used, and if negative, all remaining
+	// transactions are returned.
+	Limit int
+	// Address, if non-nil, restricts the results to transactions relevant to
+	// the specified address, which must be owned by the wallet.
+	Address *types.UnlockHash
+	// MinHeight and MaxHeight, if non-zero, restrict the results to
+	// transactions confirmed within the specified (inclusive) range of
+	// heights.
+	MinHeight types.BlockHeight
+	MaxHeight types.BlockHeight
+	// Since and Until, if non-zero, restrict the results to transactions
+	// confirmed within the specified (inclusive) range of times.
+	Since time.Time
+	Until time.Time
+	// Direction, if non-empty, restricts the results to incoming or outgoing
+	// transactions.
+	Direction TransactionDirection
+	// MinAmount, if non-zero, restricts the results to transactions whose net
+	// value (the difference between their credit and debit) is at least
+	// MinAmount.
+	MinAmount types.Currency
+}
+
+// A TransactionDirection distinguishes incoming transactions, which credit the
+// wallet more than they debit it, from outgoing transactions, which debit the
+// wallet more than they credit it.
+type TransactionDirection string
+
+// Transaction directions.
+const (
+	DirectionIncoming TransactionDirection = "incoming"
+	DirectionOutgoing TransactionDirection = "outgoing"
+)
+
+func (q TransactionQuery) values() url.Values {
+	v := pageQuery(q.Cursor, q.Limit)
+	if q.Address != nil {
+		v.Set("addr", q.Address.String())
+	}
+	if q.MinHeight != 0 {
+		v.Set("minHeight", strconv.FormatUint(uint64(q.MinHeight), 10))
+	}
+	if q.MaxHeight != 0 {
+		v.Set("maxHeight", strconv.FormatUint(uint64(q.MaxHeight), 10))
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", strconv.FormatInt(q.Since.Unix(), 10))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", strconv.FormatInt(q.Until.Unix(), 10))
+	}
+	if q.Direction != "" {
+		v.Set("direction", string(q.Direction))
+	}
+	if !q.MinAmount.IsZero() {
+		v.Set("minAmount", q.MinAmount.String())
+	}
+	return v
+}
+
+// TransactionsPage returns the IDs of the transactions specified by q, along
+// with the cursor for the next page. When no transactions remain, the returned
+// cursor is empty.
+func (c *Client) TransactionsPage(q TransactionQuery) (txids []types.TransactionID, next string, err error) {
+	next, err = c.getPage("/transactions?"+q.values().Encode(), &txids)
+	return
+}
+
+// TransactionHistory returns the transactions specified by q, along with their
+// credit, debit, fee, confirmation, and memo information, and the cursor for
+// the next page. When no transactions remain, the returned cursor is empty.
+func (c *Client) TransactionHistory(q TransactionQuery) (txns []ResponseTransactionsID, next string, err error) {
+	v := q.values()
+	v.Set("full", "true")
+	next, err = c.getPage("/transactions?"+v.Encode(), &txns)
+	return
+}
+
+// TransactionIterator returns an iterator over the IDs of the transactions
+// specified by q. q.Limit is used as the page size.
+func (c *Client) TransactionIterator(q TransactionQuery) *TransactionIterator {
+	return &TransactionIterator{c: c, q: q}
+}
+
 // TransactionsByAddress lists the IDs of transactions relevant to the specified
 // address, which must be owned by the wallet. If max < 0, all such IDs are
 // returned; otherwise, at most max IDs are returned. The IDs are ordered
@@ -230,6 +614,43 @@ func (c *Client) Transaction(txid types.TransactionID) (txn ResponseTransactions
 	return
 }
 
+// FundTransaction returns an unsigned transaction containing the requested
+// outputs, funded by the wallet's confirmed outputs, along with the
+// signatures required to spend those outputs.
+func (c *Client) FundTransaction(rtf RequestTxnFund) (resp ResponseTxnFund, err error) {
+	err = c.post("/txn/fund", rtf, &resp)
+	return
+}
+
+// ConsolidateOutputs returns a series of unsigned transactions that merge the
+// wallet's small outputs into a single address.
+func (c *Client) ConsolidateOutputs(rtc RequestTxnConsolidate) (txns []ResponseTxnFund, err error) {
+	err = c.post("/txn/consolidate", rtc, &txns)
+	return
+}
+
+// SweepTransaction returns an unsigned transaction that sends the value of all
+// of the wallet's confirmed outputs (or those permitted by rts), minus a miner
+// fee, to a single address.
+func (c *Client) SweepTransaction(rts RequestTxnSweep) (resp ResponseTxnFund, err error) {
+	err = c.post("/txn/sweep", rts, &resp)
+	return
+}
+
+// TransactionFee returns the encoded size of txn, assuming that any missing
+// signatures are supplied and that a miner fee is present, along with the
+// total fee it requires at each fee tier.
+func (c *Client) TransactionFee(txn types.Transaction) (resp ResponseTxnFee, err error) {
+	err = c.post("/txn/fee", txn, &resp)
+	return
+}
+
+// ValidateTransactionSet checks txnSet for errors without broadcasting it.
+func (c *Client) ValidateTransactionSet(txnSet []types.Transaction) (resp ResponseValidate, err error) {
+	err = c.post("/validate", txnSet, &resp)
+	return
+}
+
 // UnconfirmedParents returns any parents of txn that are in Limbo. These
 // transactions will need to be included in the transaction set passed to
 // Broadcast.
@@ -245,6 +666,97 @@ func (c *Client) UnspentOutputs(limbo bool) (utxos []wallet.UnspentOutput, err e
 	return
 }
 
+// An OutputSort specifies the order of the outputs returned by
+// QueryOutputs.
+type OutputSort string
+
+// Output sort orders.
+const (
+	SortLargest  OutputSort = "largest"
+	SortSmallest OutputSort = "smallest"
+	SortOldest   OutputSort = "oldest"
+	SortNewest   OutputSort = "newest"
+)
+
+// An OutputQuery specifies a subset of the wallet's unspent outputs.
+type OutputQuery struct {
+	// Limbo and ExcludeReserved have the same meaning as in UnspentOutputs
+	// and UnreservedOutputs, respectively.
+	Limbo           bool
+	ExcludeReserved bool
+	// Addresses, if non-empty, restricts the results to outputs sent to the
+	// specified addresses.
+	Addresses []types.UnlockHash
+	// MinValue and MaxValue, if non-zero, restrict the results to outputs
+	// within the specified (inclusive) range of values.
+	MinValue types.Currency
+	MaxValue types.Currency
+	// MinConfirmations, if non-zero, restricts the results to outputs with at
+	// least the specified number of confirmations. Outputs created by Limbo
+	// transactions have zero confirmations.
+	MinConfirmations uint64
+	// Sort, if non-empty, specifies the order of the results.
+	Sort OutputSort
+}
+
+// QueryOutputs returns the unspent outputs specified by q.
+func (c *Client) QueryOutputs(q OutputQuery) (utxos []wallet.UnspentOutput, err error) {
+	v := url.Values{}
+	v.Set("limbo", strconv.FormatBool(q.Limbo))
+	v.Set("excludeReserved", strconv.FormatBool(q.ExcludeReserved))
+	for _, addr := range q.Addresses {
+		v.Add("addr", addr.String())
+	}
+	if !q.MinValue.IsZero() {
+		v.Set("minValue", q.MinValue.String())
+	}
+	if !q.MaxValue.IsZero() {
+		v.Set("maxValue", q.MaxValue.String())
+	}
+	if q.MinConfirmations != 0 {
+		v.Set("minConfirmations", strconv.FormatUint(q.MinConfirmations, 10))
+	}
+	if q.Sort != "" {
+		v.Set("sort", string(q.Sort))
+	}
+	err = c.get("/utxos?"+v.Encode(), &utxos)
+	return
+}
+
+// UnreservedOutputs is like UnspentOutputs, but excludes reserved outputs.
+func (c *Client) UnreservedOutputs(limbo bool) (utxos []wallet.UnspentOutput, err error) {
+	err = c.get("/utxos?limbo="+strconv.FormatBool(limbo)+"&excludeReserved=true", &utxos)
+	return
+}
+
+// Reservations returns the currently-reserved outputs.
+func (c *Client) Reservations() (res []Reservation, err error) {
+	err = c.get("/reservations", &res)
+	return
+}
+
+// ReserveOutputs reserves the specified outputs for duration d, preventing them
+// from being selected by other funding requests. If any of the outputs are
+// already reserved, none are reserved and an error is returned. Reserving an
+// output that has already been reserved by the caller fails; release it first.
+// Each returned Reservation contains the token required to release it.
+func (c *Client) ReserveOutputs(ids []types.SiacoinOutputID, d time.Duration) (res []Reservation, err error) {
+	secs := uint64((d + time.Second - 1) / time.Second)
+	err = c.post("/reservations", RequestReservations{IDs: ids, Duration: secs}, &res)
+	return
+}
+
+// ReleaseOutputs releases any reservations on the specified outputs, which
+// must have been created with token.
+func (c *Client) ReleaseOutputs(token string, ids []types.SiacoinOutputID) error {
+	for _, id := range ids {
+		if err := c.delete("/reservations/" + id.String() + "?token=" + url.QueryEscape(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // AddAddress adds a set of address metadata to the wallet. Future
 // transactions and outputs relevant to this address will be considered relevant
 // to the wallet.
@@ -255,6 +767,12 @@ func (c *Client) AddAddress(info wallet.SeedAddressInfo) error {
 	return c.post("/addresses", info, new(types.UnlockHash))
 }
 
+// AddAddressWithMetadata is like AddAddress, but also attaches metadata to the
+// address.
+func (c *Client) AddAddressWithMetadata(info wallet.SeedAddressInfo, md AddressMetadata) error {
+	return c.post("/addresses", RequestAddresses{info, md}, new(types.UnlockHash))
+}
+
 // RemoveAddress removes an address from the wallet. Future transactions and
 // outputs relevant to this address will not be considered relevant to the
 // wallet.
@@ -265,14 +783,36 @@ func (c *Client) RemoveAddress(addr types.UnlockHash) error {
 	return c.delete("/addresses/" + addr.String())
 }
 
+// A ProtoWalletOption configures a wallet returned by ProtoWallet.
+type ProtoWalletOption func(*protoBridge)
+
+// WithCoinSelector sets the strategy used to select the outputs that fund a
+// transaction. The default is RandomCoins.
+func WithCoinSelector(cs CoinSelector) ProtoWalletOption {
+	return func(c *protoBridge) {
+		c.selector = cs
+	}
+}
+
+// WithCoinControl restricts the outputs that the wallet may spend.
+func WithCoinControl(cc CoinControl) ProtoWalletOption {
+	return func(c *protoBridge) {
+		c.cc = cc
+	}
+}
+
 // ProtoWallet returns a wrapped Client that implements the proto.Wallet
 // interface using an in-memory seed.
-func (c *Client) ProtoWallet(seed wallet.Seed) proto.Wallet {
-	return &protoBridge{
-		Client: c,
-		seed:   seed,
-		used:   make(map[types.SiacoinOutputID]struct{}),
+func (c *Client) ProtoWallet(seed wallet.Seed, opts ...ProtoWalletOption) proto.Wallet {
+	pb := &protoBridge{
+		Client:   c,
+		seed:     seed,
+		selector: RandomCoins(),
 	}
+	for _, opt := range opts {
+		opt(pb)
+	}
+	return pb
 }
 
 // ProtoTransactionPool returns a wrapped Client that implements the
@@ -281,21 +821,44 @@ func (c *Client) ProtoTransactionPool() proto.TransactionPool {
 	return &protoBridge{Client: c}
 }
 
-// NewClient returns a client that communicates with a walrus server listening
-// on the specified address.
-func NewClient(addr string) *Client {
+// normalizeAddr adds a scheme to addr if necessary.
+func normalizeAddr(addr string) string {
 	// use https by default
 	if !strings.HasPrefix(addr, "https://") && !strings.HasPrefix(addr, "http://") {
 		addr = "https://" + addr
 	}
-	return &Client{addr}
+	return addr
+}
+
+func newClient(addrs []string, opts []ClientOption) *Client {
+	c := &Client{
+		eps:    &endpointSet{addrs: addrs},
+		hc:     http.DefaultClient,
+		ctx:    context.Background(),
+		header: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
+// NewClient returns a client that communicates with a walrus server listening
+// on the specified address.
+func NewClient(addr string, opts ...ClientOption) *Client {
+	return newClient([]string{normalizeAddr(addr)}, opts)
+}
+
+// protoReservationDuration is the duration for which protoBridge reserves the
+// outputs it selects.
+const protoReservationDuration = time.Hour
+
 type protoBridge struct {
 	*Client
-	seed wallet.Seed
-	used map[types.SiacoinOutputID]struct{}
-	mu   sync.Mutex
+	seed     wallet.Seed
+	cc       CoinControl
+	selector CoinSelector
+	mu       sync.Mutex
 }
 
 // proto.Wallet methods
@@ -325,28 +888,75 @@ func (c *protoBridge) FundTransaction(txn *types.Transaction, amount types.Curre
 	if amount.IsZero() {
 		return nil, nil, nil
 	}
-	limboOutputs, err := c.Client.UnspentOutputs(true)
+	// another client may reserve the outputs we select before we can reserve
+	// them ourselves; if so, try again
+	var fundingOutputs []wallet.UnspentOutput
+	var outputSum types.Currency
+	var ids []types.SiacoinOutputID
+	var res []Reservation
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		fundingOutputs, outputSum, err = c.selectOutputs(amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = make([]types.SiacoinOutputID, len(fundingOutputs))
+		for i := range ids {
+			ids[i] = fundingOutputs[i].ID
+		}
+		res, err = c.Client.ReserveOutputs(ids, protoReservationDuration)
+		if !errors.Is(err, ErrOutputReserved) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, nil, err
 	}
-	unused := limboOutputs[:0]
-	for _, lo := range limboOutputs {
-		if _, ok := c.used[lo.ID]; !ok {
-			unused = append(unused, lo)
+	discard := func() { c.Client.ReleaseOutputs(res[0].Token, ids) }
+
+	var toSign []crypto.Hash
+	for _, o := range fundingOutputs {
+		info, err := c.Client.AddressInfo(o.UnlockHash)
+		if err != nil {
+			discard()
+			return nil, nil, err
+		}
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         o.ID,
+			UnlockConditions: info.UnlockConditions,
+		})
+		txn.TransactionSignatures = append(txn.TransactionSignatures, wallet.StandardTransactionSignature(crypto.Hash(o.ID)))
+		toSign = append(toSign, crypto.Hash(o.ID))
+	}
+	if change := outputSum.Sub(amount); !change.IsZero() {
+		c.mu.Unlock()
+		changeAddr, err := c.Address()
+		c.mu.Lock()
+		if err != nil {
+			discard()
+			return nil, nil, err
 		}
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+			UnlockHash: changeAddr,
+			Value:      change,
+		})
 	}
-	limboOutputs = unused
-	confirmedOutputs, err := c.Client.UnspentOutputs(false)
+	return toSign, discard, nil
+}
+
+// selectOutputs selects unreserved outputs whose total value is at least
+// amount, preferring confirmed outputs that are not spent in Limbo.
+func (c *protoBridge) selectOutputs(amount types.Currency) ([]wallet.UnspentOutput, types.Currency, error) {
+	limboOutputs, err := c.Client.UnreservedOutputs(true)
 	if err != nil {
-		return nil, nil, err
+		return nil, types.ZeroCurrency, err
 	}
-	unused = confirmedOutputs[:0]
-	for _, co := range confirmedOutputs {
-		if _, ok := c.used[co.ID]; !ok {
-			unused = append(unused, co)
-		}
+	confirmedOutputs, err := c.Client.UnreservedOutputs(false)
+	if err != nil {
+		return nil, types.ZeroCurrency, err
 	}
-	confirmedOutputs = unused
+	limboOutputs = c.cc.filter(limboOutputs)
+	confirmedOutputs = c.cc.filter(confirmedOutputs)
 
 	var outputs []wallet.UnspentOutput
 	for _, lo := range limboOutputs {
@@ -367,59 +977,71 @@ func (c *protoBridge) FundTransaction(txn *types.Transaction, amount types.Curre
 	}
 	if balance.Cmp(amount) < 0 {
 		if limboBalance.Cmp(amount) < 0 {
-			return nil, nil, wallet.ErrInsufficientFunds
+			return nil, types.ZeroCurrency, wallet.ErrInsufficientFunds
 		}
 		outputs = limboOutputs
 	}
-	frand.Shuffle(len(outputs), reflect.Swapper(outputs))
-	var fundingOutputs []wallet.UnspentOutput
-	var outputSum types.Currency
-	for i, o := range outputs {
-		if outputSum = outputSum.Add(o.Value); outputSum.Cmp(amount) >= 0 {
-			fundingOutputs = outputs[:i+1]
-			break
+	required, outputs, err := c.cc.partition(outputs)
+	if err != nil {
+		return nil, types.ZeroCurrency, err
+	}
+	selected := required
+	if requiredSum := wallet.SumOutputs(required); requiredSum.Cmp(amount) < 0 {
+		fundingOutputs, err := c.selector.SelectCoins(outputs, amount.Sub(requiredSum))
+		if err != nil {
+			return nil, types.ZeroCurrency, err
 		}
+		selected = append(selected, fundingOutputs...)
 	}
-	sort.Slice(fundingOutputs, func(i, j int) bool {
-		return fundingOutputs[i].Value.Cmp(fundingOutputs[j].Value) < 0
-	})
-	for outputSum.Sub(fundingOutputs[0].Value).Cmp(amount) >= 0 {
-		outputSum = outputSum.Sub(fundingOutputs[0].Value)
-		fundingOutputs = fundingOutputs[1:]
+	outputSum := wallet.SumOutputs(selected)
+	if outputSum.Cmp(amount) < 0 {
+		return nil, types.ZeroCurrency, wallet.ErrInsufficientFunds
 	}
-	var toSign []crypto.Hash
-	for _, o := range fundingOutputs {
-		info, err := c.Client.AddressInfo(o.UnlockHash)
-		if err != nil {
-			return nil, nil, err
+	return selected, outputSum, nil
+}
+
+// filter returns the outputs that cc permits to be spent.
+func (cc CoinControl) filter(outputs []wallet.UnspentOutput) []wallet.UnspentOutput {
+	excluded := make(map[types.SiacoinOutputID]struct{})
+	for _, id := range cc.Exclude {
+		excluded[id] = struct{}{}
+	}
+	included := make(map[types.SiacoinOutputID]struct{})
+	for _, id := range cc.Include {
+		included[id] = struct{}{}
+	}
+	var filtered []wallet.UnspentOutput
+	for _, o := range outputs {
+		_, inc := included[o.ID]
+		_, exc := excluded[o.ID]
+		if !exc && (inc || !cc.IncludeOnly) {
+			filtered = append(filtered, o)
 		}
-		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
-			ParentID:         o.ID,
-			UnlockConditions: info.UnlockConditions,
-		})
-		txn.TransactionSignatures = append(txn.TransactionSignatures, wallet.StandardTransactionSignature(crypto.Hash(o.ID)))
-		toSign = append(toSign, crypto.Hash(o.ID))
 	}
-	if change := outputSum.Sub(amount); !change.IsZero() {
-		c.mu.Unlock()
-		changeAddr, err := c.Address()
-		c.mu.Lock()
-		if err != nil {
-			return nil, nil, err
+	return filtered
+}
+
+// partition splits outputs into the outputs that cc requires to be spent and
+// the remainder. It returns an error if any required output is missing.
+func (cc CoinControl) partition(outputs []wallet.UnspentOutput) (required, rest []wallet.UnspentOutput, err error) {
+	byID := make(map[types.SiacoinOutputID]wallet.UnspentOutput, len(outputs))
+	for _, o := range outputs {
+		byID[o.ID] = o
+	}
+	for _, id := range cc.Include {
+		o, ok := byID[id]
+		if !ok {
+			return nil, nil, fmt.Errorf("output %v is not spendable", id)
 		}
-		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
-			UnlockHash: changeAddr,
-			Value:      change,
-		})
+		required = append(required, o)
+		delete(byID, id)
 	}
-	discard := func() {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		for _, o := range fundingOutputs {
-			delete(c.used, o.ID)
+	for _, o := range outputs {
+		if _, ok := byID[o.ID]; ok {
+			rest = append(rest, o)
 		}
 	}
-	return toSign, discard, nil
+	return required, rest, nil
 }
 
 func (c *protoBridge) SignTransaction(txn *types.Transaction, toSign []crypto.Hash) error {
@@ -427,9 +1049,10 @@ func (c *protoBridge) SignTransaction(txn *types.Transaction, toSign []crypto.Ha
 		// lazy mode: add standard sigs for every input we own
 		for _, input := range txn.SiacoinInputs {
 			info, err := c.Client.AddressInfo(input.UnlockConditions.UnlockHash())
-			if err != nil {
-				// TODO: catch errors other than "address not found"
-				continue
+			if errors.Is(err, ErrNotFound) {
+				continue // not our input
+			} else if err != nil {
+				return err
 			}
 			sk := c.seed.SecretKey(info.KeyIndex)
 			txnSig := wallet.StandardTransactionSignature(crypto.Hash(input.ParentID))
@@ -463,7 +1086,7 @@ func (c *protoBridge) SignTransaction(txn *types.Transaction, toSign []crypto.Ha
 		}
 		info, err := c.Client.AddressInfo(addr)
 		if err != nil {
-			return err
+			return fmt.Errorf("could not get info for address %v: %w", addr, err)
 		}
 		sk := c.seed.SecretKey(info.KeyIndex)
 		txn.TransactionSignatures[i].Signature = ed25519hash.Sign(sk, txn.SigHash(i, types.FoundationHardforkHeight+1))
@@ -502,6 +1125,77 @@ func (c *protoBridge) UnconfirmedParents(txn types.Transaction) ([]types.Transac
 }
 
 func (c *protoBridge) FeeEstimate() (minFee, maxFee types.Currency, err error) {
-	fee, err := c.Client.RecommendedFee()
-	return fee, fee.Mul64(3), err
+	fees, err := c.Client.Fees()
+	return fees.Min, fees.Max, err
+}
+
+// A Subscription is a stream of events from a walrus server.
+type Subscription struct {
+	ch        chan Event
+	body      io.ReadCloser
+	err       error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *Subscription) run() {
+	defer close(s.ch)
+	scanner := bufio.NewScanner(s.body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0 && len(data) > 0:
+			var e Event
+			if err := json.Unmarshal(data, &e); err != nil {
+				s.err = err
+				return
+			}
+			data = data[:0]
+			select {
+			case s.ch <- e:
+			case <-s.closed:
+				return
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			// successive data fields are joined with newlines
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+		}
+		// ignore comments, event names, and other fields
+	}
+	select {
+	case <-s.closed:
+	default:
+		s.err = scanner.Err()
+		if s.err == nil {
+			s.err = io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// Events returns a channel that delivers events as they are received. The
+// channel is closed when the subscription is closed or the stream fails; in
+// the latter case, Err returns the cause.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err returns the error that caused the stream to fail, if any. It should only
+// be called after the Events channel has been closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.body.Close()
+	})
+	return err
 }
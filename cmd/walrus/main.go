@@ -1,10 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"go.sia.tech/siad/build"
 	"go.sia.tech/siad/modules/consensus"
@@ -26,6 +32,37 @@ var (
     walrus [flags]
 
 Initializes the wallet and begins serving the walrus API.
+
+If -password is supplied (or the WALRUS_API_PASSWORD environment variable is
+set), clients must authenticate via HTTP Basic Auth, and are granted access to
+all routes. Additional credentials with restricted access may be supplied via
+-token, whose argument has the form token=scope1,scope2. Tokens may not contain
+'='. The available scopes are read, broadcast, addresses, and memos.
+
+To serve the API over HTTPS, supply a certificate and key via -tls-cert and
+-tls-key. Alternatively, supply -tls-selfsigned to generate a self-signed
+certificate on first run (stored in -dir unless -tls-cert and -tls-key are
+supplied). The certificate's fingerprint is logged on startup; clients can
+pin it to safely trust a self-signed certificate.
+
+To allow browser-based wallets to access the API directly, supply a
+comma-separated list of permitted origins via -cors-origins, or "*" to permit
+any origin.
+
+To receive notifications of incoming payments, supply a URL via -webhook-url.
+Whenever a wallet address receives siacoins, a JSON payload will be POSTed to
+the URL, signed with the secret supplied via -webhook-secret (or the
+WALRUS_WEBHOOK_SECRET environment variable). Failed deliveries are retried,
+and pending deliveries persist across restarts.
+
+Transactions in Limbo are resubmitted to the transaction pool on startup and
+every -rebroadcast-interval thereafter (0 disables rebroadcasting). If -limbo-max-age is
+supplied, transactions that remain in Limbo for longer are flagged as stale in
+/limbo, or, if -limbo-expire is supplied, removed from Limbo.
+
+Transaction memos may be up to -max-memo-size bytes. Their data is stored in
+the wallet database, and their content types in memos.json. Address labels,
+tags, and metadata are stored in labels.json.
 `
 	versionUsage = rootUsage
 
@@ -42,6 +79,27 @@ blockchain.
 
 var usage = flagg.SimpleUsage(flagg.Root, rootUsage)
 
+// tokenFlags is a repeatable flag.Value for bearer tokens and their scopes.
+type tokenFlags []walrus.ServerOption
+
+func (t *tokenFlags) String() string { return "" }
+
+func (t *tokenFlags) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if s == "" || i == 0 {
+		return errors.New("empty token")
+	} else if i == -1 {
+		*t = append(*t, walrus.AuthToken(s))
+		return nil
+	}
+	scopes, err := walrus.ParseScopes(s[i+1:])
+	if err != nil {
+		return err
+	}
+	*t = append(*t, walrus.AuthToken(s[:i], scopes...))
+	return nil
+}
+
 func main() {
 	log.SetFlags(0)
 
@@ -49,6 +107,19 @@ func main() {
 	rootCmd.Usage = flagg.SimpleUsage(rootCmd, rootUsage)
 	addr := rootCmd.String("http", ":9380", "host:port to serve on")
 	dir := rootCmd.String("dir", ".", "directory to store in")
+	password := rootCmd.String("password", os.Getenv("WALRUS_API_PASSWORD"), "API password granting access to all routes")
+	tlsCert := rootCmd.String("tls-cert", "", "path to TLS certificate")
+	tlsKey := rootCmd.String("tls-key", "", "path to TLS private key")
+	tlsSelfSigned := rootCmd.Bool("tls-selfsigned", false, "generate a self-signed TLS certificate if none exists")
+	corsOrigins := rootCmd.String("cors-origins", "", "comma-separated list of origins permitted to make cross-origin requests")
+	webhookURL := rootCmd.String("webhook-url", "", "URL to notify of incoming payments")
+	webhookSecret := rootCmd.String("webhook-secret", os.Getenv("WALRUS_WEBHOOK_SECRET"), "secret used to sign webhook payloads")
+	rebroadcastInterval := rootCmd.Duration("rebroadcast-interval", 10*time.Minute, "interval between rebroadcasts of Limbo transactions (0 to disable)")
+	limboMaxAge := rootCmd.Duration("limbo-max-age", 0, "age after which Limbo transactions are considered stale")
+	limboExpire := rootCmd.Bool("limbo-expire", false, "remove stale transactions from Limbo")
+	maxMemoSize := rootCmd.Int("max-memo-size", walrus.DefaultMaxMemoSize, "maximum size of a transaction memo, in bytes")
+	var tokens tokenFlags
+	rootCmd.Var(&tokens, "token", "API bearer token, optionally followed by =scope1,scope2 (may be repeated)")
 	versionCmd := flagg.New("version", versionUsage)
 	resetCmd := flagg.New("reset", resetUsage)
 	resetDir := resetCmd.String("dir", ".", "directory where wallet is stored")
@@ -76,7 +147,43 @@ func main() {
 			rootCmd.Usage()
 			return
 		}
-		if err := start(*dir, *addr); err != nil {
+		opts := []walrus.ServerOption(tokens)
+		if *password != "" {
+			opts = append(opts, walrus.AuthPassword(*password))
+		}
+		if *corsOrigins != "" {
+			opts = append(opts, walrus.CORS(walrus.CORSOptions{
+				AllowedOrigins: strings.Split(*corsOrigins, ","),
+			}))
+		}
+		if *tlsSelfSigned {
+			if *tlsCert == "" {
+				*tlsCert = filepath.Join(*dir, "walrus.crt")
+			}
+			if *tlsKey == "" {
+				*tlsKey = filepath.Join(*dir, "walrus.key")
+			}
+		} else if (*tlsCert == "") != (*tlsKey == "") {
+			log.Fatal("-tls-cert and -tls-key must be supplied together")
+		}
+		if *webhookURL != "" && *webhookSecret == "" {
+			log.Fatal("-webhook-url requires a secret")
+		}
+		if *limboExpire && *limboMaxAge == 0 {
+			log.Fatal("-limbo-expire requires -limbo-max-age")
+		}
+		var rbOpts *walrus.RebroadcastOptions
+		if *rebroadcastInterval > 0 {
+			rbOpts = &walrus.RebroadcastOptions{
+				Interval: *rebroadcastInterval,
+				MaxAge:   *limboMaxAge,
+				Expire:   *limboExpire,
+			}
+		}
+		if *maxMemoSize <= 0 {
+			log.Fatal("-max-memo-size must be positive")
+		}
+		if err := start(*dir, *addr, *tlsCert, *tlsKey, *tlsSelfSigned, *webhookURL, *webhookSecret, rbOpts, *maxMemoSize, opts); err != nil {
 			log.Fatal(err)
 		}
 
@@ -91,7 +198,7 @@ func main() {
 	}
 }
 
-func start(dir string, APIaddr string) error {
+func start(dir string, APIaddr string, tlsCert, tlsKey string, tlsSelfSigned bool, webhookURL, webhookSecret string, rbOpts *walrus.RebroadcastOptions, maxMemoSize int, opts []walrus.ServerOption) error {
 	g, err := gateway.New(":9381", true, filepath.Join(dir, "gateway"))
 	if err != nil {
 		return err
@@ -111,14 +218,69 @@ func start(dir string, APIaddr string) error {
 		return err
 	}
 	w := wallet.New(store)
-	err = cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	hub, err := walrus.NewEventHub(w, walrus.EventHubOptions{
+		RevertedPath: filepath.Join(dir, "reverted.json"),
+	})
+	if err != nil {
+		return err
+	}
+	err = cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	if err != nil {
+		return err
+	}
+	if webhookURL != "" {
+		wn, err := walrus.NewWebhookNotifier(hub, walrus.WebhookOptions{
+			URL:       webhookURL,
+			Secret:    webhookSecret,
+			QueuePath: filepath.Join(dir, "webhooks.json"),
+		})
+		if err != nil {
+			return err
+		}
+		defer wn.Close()
+	}
+	if rbOpts != nil {
+		rbOpts.Hub = hub
+		rb := walrus.NewRebroadcaster(w, tp, *rbOpts)
+		defer rb.Close()
+		opts = append(opts, walrus.Rebroadcasts(rb))
+	}
+	ms, err := walrus.NewMemoStore(w, walrus.MemoOptions{
+		Path:    filepath.Join(dir, "memos.json"),
+		MaxSize: maxMemoSize,
+	})
+	if err != nil {
+		return err
+	}
+	ls, err := walrus.NewLabelStore(walrus.LabelOptions{
+		Path: filepath.Join(dir, "labels.json"),
+	})
 	if err != nil {
 		return err
 	}
-	ss := walrus.NewServer(w, tp)
+	opts = append(opts, walrus.Events(hub), walrus.Memos(ms), walrus.Labels(ls))
+	ss := walrus.NewServer(w, tp, opts...)
 
-	log.Printf("Listening on %v...", APIaddr)
-	return http.ListenAndServe(APIaddr, ss)
+	if tlsCert == "" {
+		log.Printf("Listening on %v...", APIaddr)
+		return http.ListenAndServe(APIaddr, ss)
+	}
+	host, _, _ := net.SplitHostPort(APIaddr)
+	cert, err := loadCertificate(tlsCert, tlsKey, host, tlsSelfSigned)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:    APIaddr,
+		Handler: ss,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	log.Printf("Certificate fingerprint (SHA-256): %v", walrus.CertificateFingerprint(cert.Certificate[0]))
+	log.Printf("Listening on %v (HTTPS)...", APIaddr)
+	return srv.ListenAndServeTLS("", "")
 }
 
 func reset(dir string) error {
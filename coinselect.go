@@ -0,0 +1,177 @@
+package walrus
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/frand"
+	"lukechampine.com/us/wallet"
+)
+
+// A CoinSelector selects the outputs used to fund a transaction.
+type CoinSelector interface {
+	// SelectCoins returns a subset of outputs whose total value is at least
+	// amount, or wallet.ErrInsufficientFunds if no such subset exists.
+	SelectCoins(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error)
+}
+
+// CoinSelectorFunc is an adapter that allows an ordinary function to be used
+// as a CoinSelector.
+type CoinSelectorFunc func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error)
+
+// SelectCoins implements CoinSelector.
+func (fn CoinSelectorFunc) SelectCoins(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+	return fn(outputs, amount)
+}
+
+// sortOutputs sorts outputs by value, breaking ties by ID so that the order
+// is deterministic.
+func sortOutputs(outputs []wallet.UnspentOutput, descending bool) {
+	sort.Slice(outputs, func(i, j int) bool {
+		if c := outputs[i].Value.Cmp(outputs[j].Value); c != 0 {
+			return (c > 0) == descending
+		}
+		return bytes.Compare(outputs[i].ID[:], outputs[j].ID[:]) < 0
+	})
+}
+
+// takeUntil returns the shortest prefix of outputs whose total value is at
+// least amount.
+func takeUntil(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+	var sum types.Currency
+	for i, o := range outputs {
+		if sum = sum.Add(o.Value); sum.Cmp(amount) >= 0 {
+			return outputs[:i+1], nil
+		}
+	}
+	return nil, wallet.ErrInsufficientFunds
+}
+
+// RandomCoins returns a CoinSelector that selects outputs in random order,
+// then discards the smallest selected outputs that are not needed. This is the
+// default strategy used by Client.ProtoWallet.
+func RandomCoins() CoinSelector {
+	return CoinSelectorFunc(func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+		outputs = append([]wallet.UnspentOutput(nil), outputs...)
+		frand.Shuffle(len(outputs), reflect.Swapper(outputs))
+		selected, err := takeUntil(outputs, amount)
+		if err != nil {
+			return nil, err
+		}
+		sortOutputs(selected, false)
+		sum := wallet.SumOutputs(selected)
+		for sum.Sub(selected[0].Value).Cmp(amount) >= 0 {
+			sum = sum.Sub(selected[0].Value)
+			selected = selected[1:]
+		}
+		return selected, nil
+	})
+}
+
+// LargestFirst returns a CoinSelector that selects the largest outputs first.
+// This minimizes the number of inputs (and thus the fee) of the transaction.
+func LargestFirst() CoinSelector {
+	return CoinSelectorFunc(func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+		outputs = append([]wallet.UnspentOutput(nil), outputs...)
+		sortOutputs(outputs, true)
+		return takeUntil(outputs, amount)
+	})
+}
+
+// SmallestFirst returns a CoinSelector that selects the smallest outputs
+// first. This consolidates small outputs over time, at the cost of larger
+// transactions.
+func SmallestFirst() CoinSelector {
+	return CoinSelectorFunc(func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+		outputs = append([]wallet.UnspentOutput(nil), outputs...)
+		sortOutputs(outputs, false)
+		return takeUntil(outputs, amount)
+	})
+}
+
+// DefaultBranchAndBoundTries is the number of combinations explored by
+// BranchAndBound when maxTries is not positive.
+const DefaultBranchAndBoundTries = 100000
+
+// BranchAndBound returns a CoinSelector that searches for a set of outputs
+// whose total value is exactly amount, so that the transaction requires no
+// change output. The search explores at most maxTries combinations; if
+// maxTries <= 0, DefaultBranchAndBoundTries is used. If no exact match is
+// found, fallback is used instead. If fallback is nil, LargestFirst is used.
+func BranchAndBound(maxTries int, fallback CoinSelector) CoinSelector {
+	if maxTries <= 0 {
+		maxTries = DefaultBranchAndBoundTries
+	}
+	if fallback == nil {
+		fallback = LargestFirst()
+	}
+	return CoinSelectorFunc(func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+		sorted := append([]wallet.UnspentOutput(nil), outputs...)
+		sortOutputs(sorted, true)
+		// remaining[i] is the total value of sorted[i:]
+		remaining := make([]types.Currency, len(sorted)+1)
+		for i := len(sorted) - 1; i >= 0; i-- {
+			remaining[i] = remaining[i+1].Add(sorted[i].Value)
+		}
+		if remaining[0].Cmp(amount) < 0 {
+			return nil, wallet.ErrInsufficientFunds
+		}
+
+		// depth-first search, including each output before excluding it
+		var selected []wallet.UnspentOutput
+		tries := 0
+		var search func(i int, sum types.Currency) bool
+		search = func(i int, sum types.Currency) bool {
+			if c := sum.Cmp(amount); c == 0 {
+				return true
+			} else if c > 0 || i == len(sorted) || sum.Add(remaining[i]).Cmp(amount) < 0 {
+				return false
+			} else if tries++; tries > maxTries {
+				return false
+			}
+			selected = append(selected, sorted[i])
+			if search(i+1, sum.Add(sorted[i].Value)) {
+				return true
+			}
+			selected = selected[:len(selected)-1]
+			return search(i+1, sum)
+		}
+		if search(0, types.ZeroCurrency) {
+			return selected, nil
+		}
+		return fallback.SelectCoins(outputs, amount)
+	})
+}
+
+// SingleAddress returns a CoinSelector that only selects outputs sent to a
+// single address, so that the transaction does not link multiple addresses
+// together. It spends every output of the address with the smallest
+// sufficient balance, so that no outputs of that address remain to be linked
+// to it later.
+func SingleAddress() CoinSelector {
+	return CoinSelectorFunc(func(outputs []wallet.UnspentOutput, amount types.Currency) ([]wallet.UnspentOutput, error) {
+		byAddr := make(map[types.UnlockHash][]wallet.UnspentOutput)
+		for _, o := range outputs {
+			byAddr[o.UnlockHash] = append(byAddr[o.UnlockHash], o)
+		}
+		var best []wallet.UnspentOutput
+		var bestAddr types.UnlockHash
+		var bestSum types.Currency
+		for addr, os := range byAddr {
+			sum := wallet.SumOutputs(os)
+			if sum.Cmp(amount) < 0 {
+				continue
+			}
+			c := sum.Cmp(bestSum)
+			if best == nil || c < 0 || (c == 0 && bytes.Compare(addr[:], bestAddr[:]) < 0) {
+				best, bestAddr, bestSum = os, addr, sum
+			}
+		}
+		if best == nil {
+			return nil, wallet.ErrInsufficientFunds
+		}
+		return best, nil
+	})
+}
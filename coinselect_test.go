@@ -0,0 +1,142 @@
+package walrus
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+func testOutputs(addrs []types.UnlockHash, values ...uint64) []wallet.UnspentOutput {
+	outputs := make([]wallet.UnspentOutput, len(values))
+	for i, v := range values {
+		outputs[i].ID[0] = byte(i + 1)
+		outputs[i].Value = types.SiacoinPrecision.Mul64(v)
+		if len(addrs) > 0 {
+			outputs[i].UnlockHash = addrs[i%len(addrs)]
+		}
+	}
+	return outputs
+}
+
+func outputValues(outputs []wallet.UnspentOutput) []uint64 {
+	values := make([]uint64, len(outputs))
+	for i, o := range outputs {
+		values[i] = o.Value.Div(types.SiacoinPrecision).Big().Uint64()
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	outputs := testOutputs(nil, 1, 2, 3, 5, 8, 13)
+	sc := types.SiacoinPrecision.Mul64
+
+	tests := []struct {
+		name   string
+		cs     CoinSelector
+		amount types.Currency
+		exp    []uint64
+	}{
+		{"largest", LargestFirst(), sc(14), []uint64{8, 13}},
+		{"largest exact", LargestFirst(), sc(13), []uint64{13}},
+		{"smallest", SmallestFirst(), sc(7), []uint64{1, 2, 3, 5}},
+		{"smallest all", SmallestFirst(), sc(32), []uint64{1, 2, 3, 5, 8, 13}},
+		{"bnb exact", BranchAndBound(1000, nil), sc(17), []uint64{1, 3, 13}},
+		{"bnb single", BranchAndBound(1000, nil), sc(5), []uint64{5}},
+		{"bnb all", BranchAndBound(1000, nil), sc(32), []uint64{1, 2, 3, 5, 8, 13}},
+		{"bnb fallback", BranchAndBound(1000, nil), sc(31).Add(types.NewCurrency64(1)), []uint64{1, 2, 3, 5, 8, 13}},
+		{"bnb fallback smallest", BranchAndBound(1000, SmallestFirst()), sc(2).Add(types.NewCurrency64(1)), []uint64{1, 2}},
+		{"bnb exhausted", BranchAndBound(1, nil), sc(17), []uint64{8, 13}},
+		{"bnb default tries", BranchAndBound(0, nil), sc(17), []uint64{1, 3, 13}},
+		{"bnb negative tries", BranchAndBound(-1, nil), sc(17), []uint64{1, 3, 13}},
+	}
+	for _, test := range tests {
+		selected, err := test.cs.SelectCoins(outputs, test.amount)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if vals := outputValues(selected); !reflect.DeepEqual(vals, test.exp) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.exp, vals)
+		}
+	}
+
+	// all selectors should report insufficient funds
+	for _, cs := range []CoinSelector{RandomCoins(), LargestFirst(), SmallestFirst(), BranchAndBound(1000, nil), SingleAddress()} {
+		if _, err := cs.SelectCoins(outputs, sc(33)); err != wallet.ErrInsufficientFunds {
+			t.Error("expected ErrInsufficientFunds, got", err)
+		}
+	}
+
+	// selectors should not modify their input
+	orig := append([]wallet.UnspentOutput(nil), outputs...)
+	for _, cs := range []CoinSelector{RandomCoins(), LargestFirst(), SmallestFirst(), BranchAndBound(1000, nil)} {
+		cs.SelectCoins(outputs, sc(10))
+	}
+	if !reflect.DeepEqual(outputs, orig) {
+		t.Error("selector modified its input")
+	}
+}
+
+func TestRandomCoins(t *testing.T) {
+	outputs := testOutputs(nil, 1, 2, 3, 5, 8, 13)
+	amount := types.SiacoinPrecision.Mul64(10)
+	for i := 0; i < 100; i++ {
+		selected, err := RandomCoins().SelectCoins(outputs, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the selection must be sufficient, and removing its smallest output
+		// must make it insufficient
+		sum := wallet.SumOutputs(selected)
+		if sum.Cmp(amount) < 0 {
+			t.Fatal("selection is insufficient:", outputValues(selected))
+		}
+		smallest := selected[0].Value
+		for _, o := range selected {
+			if o.Value.Cmp(smallest) < 0 {
+				smallest = o.Value
+			}
+		}
+		if sum.Sub(smallest).Cmp(amount) >= 0 {
+			t.Fatal("selection contains an unnecessary output:", outputValues(selected))
+		}
+	}
+}
+
+func TestSingleAddress(t *testing.T) {
+	addrs := []types.UnlockHash{{1}, {2}, {3}}
+	// addr 1: 1+5 = 6, addr 2: 2+8 = 10, addr 3: 3+13 = 16
+	outputs := testOutputs(addrs, 1, 2, 3, 5, 8, 13)
+	sc := types.SiacoinPrecision.Mul64
+
+	tests := []struct {
+		amount types.Currency
+		addr   types.UnlockHash
+		exp    []uint64
+	}{
+		{sc(4), addrs[0], []uint64{1, 5}},
+		{sc(6), addrs[0], []uint64{1, 5}},
+		{sc(7), addrs[1], []uint64{2, 8}},
+		{sc(16), addrs[2], []uint64{3, 13}},
+	}
+	for _, test := range tests {
+		selected, err := SingleAddress().SelectCoins(outputs, test.amount)
+		if err != nil {
+			t.Fatal(err)
+		} else if vals := outputValues(selected); !reflect.DeepEqual(vals, test.exp) {
+			t.Errorf("expected %v, got %v", test.exp, vals)
+		}
+		for _, o := range selected {
+			if o.UnlockHash != test.addr {
+				t.Error("selected output from wrong address:", o.UnlockHash)
+			}
+		}
+	}
+
+	// the wallet has 32 SC in total, but no single address holds 17 SC
+	if _, err := SingleAddress().SelectCoins(outputs, sc(17)); err != wallet.ErrInsufficientFunds {
+		t.Error("expected ErrInsufficientFunds, got", err)
+	}
+}
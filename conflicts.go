@@ -0,0 +1,118 @@
+package walrus
+
+import (
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+// limboConflicts returns the transactions in limbo that can never be
+// confirmed, mapped to the ID of the confirmed transaction that invalidated
+// them. A transaction is invalidated if a confirmed transaction spends one of
+// its inputs, or if one of its Limbo parents is invalidated.
+//
+// Conflicts are derived from the wallet's confirmed history, so they are
+// detected regardless of when the transaction entered Limbo, and cleared if
+// the conflicting transaction is reverted.
+func limboConflicts(w *wallet.SeedWallet, limbo []wallet.LimboTransaction) map[types.TransactionID]types.TransactionID {
+	if len(limbo) == 0 {
+		return make(map[types.TransactionID]types.TransactionID)
+	}
+	unspent := make(map[types.SiacoinOutputID]struct{})
+	for _, o := range w.UnspentOutputs(false) {
+		unspent[o.ID] = struct{}{}
+	}
+	// spentBy indexes the confirmed spends of each address's outputs; it is
+	// only populated for addresses whose outputs are missing
+	spentBy := make(map[types.UnlockHash]map[types.SiacoinOutputID]types.TransactionID)
+	return findConflicts(limbo, func(sci types.SiacoinInput) (types.TransactionID, bool) {
+		addr := sci.UnlockConditions.UnlockHash()
+		if _, ok := unspent[sci.ParentID]; ok || !w.OwnsAddress(addr) {
+			return types.TransactionID{}, false
+		}
+		spends, ok := spentBy[addr]
+		if !ok {
+			spends = make(map[types.SiacoinOutputID]types.TransactionID)
+			for _, txid := range w.TransactionsByAddress(addr, -1) {
+				if txn, ok := w.Transaction(txid); ok {
+					for _, in := range txn.SiacoinInputs {
+						spends[in.ParentID] = txid
+					}
+				}
+			}
+			spentBy[addr] = spends
+		}
+		txid, ok := spends[sci.ParentID]
+		return txid, ok
+	})
+}
+
+// findConflicts returns the transactions in limbo that are invalidated,
+// either directly or via a Limbo parent, mapped to the ID of the confirmed
+// transaction that invalidated them. confirmedSpend returns the ID of the
+// confirmed transaction that spent the output referenced by an input, if any.
+func findConflicts(limbo []wallet.LimboTransaction, confirmedSpend func(types.SiacoinInput) (types.TransactionID, bool)) map[types.TransactionID]types.TransactionID {
+	conflicts := make(map[types.TransactionID]types.TransactionID)
+	createdBy := make(map[types.SiacoinOutputID]types.TransactionID)
+	for _, txn := range limbo {
+		txid := txn.ID()
+		for i := range txn.SiacoinOutputs {
+			createdBy[txn.SiacoinOutputID(uint64(i))] = txid
+		}
+	}
+	children := make(map[types.TransactionID][]types.TransactionID)
+	var invalidated []types.TransactionID
+	for _, txn := range limbo {
+		txid := txn.ID()
+		for _, sci := range txn.SiacoinInputs {
+			if parent, ok := createdBy[sci.ParentID]; ok {
+				children[parent] = append(children[parent], txid)
+			} else if _, ok := conflicts[txid]; ok {
+				continue
+			} else if conflict, ok := confirmedSpend(sci); ok && conflict != txid {
+				conflicts[txid] = conflict
+				invalidated = append(invalidated, txid)
+			}
+		}
+	}
+	// since Limbo is not ordered, propagate conflicts to descendants only
+	// after every direct conflict is known
+	for len(invalidated) > 0 {
+		txid := invalidated[0]
+		invalidated = invalidated[1:]
+		for _, child := range children[txid] {
+			if _, ok := conflicts[child]; !ok {
+				conflicts[child] = conflicts[txid]
+				invalidated = append(invalidated, child)
+			}
+		}
+	}
+	return conflicts
+}
+
+// limboConflicts returns the conflicts of the transactions in limbo. If the
+// server has an EventHub, its index of confirmed spends is used instead of
+// scanning the wallet's history.
+func (s *server) limboConflicts(limbo []wallet.LimboTransaction) map[types.TransactionID]types.TransactionID {
+	if s.events != nil {
+		return s.events.limboConflicts(limbo)
+	}
+	return limboConflicts(s.w, limbo)
+}
+
+// viableLimbo returns the transactions in the wallet's Limbo that have not
+// been invalidated by a confirmed transaction, along with the conflicts of
+// those that have.
+func (s *server) viableLimbo() ([]wallet.LimboTransaction, map[types.TransactionID]types.TransactionID) {
+	limbo := s.w.LimboTransactions()
+	conflicts := s.limboConflicts(limbo)
+	if len(conflicts) == 0 {
+		return limbo, conflicts
+	}
+	viable := limbo[:0]
+	for _, txn := range limbo {
+		if _, ok := conflicts[txn.ID()]; !ok {
+			viable = append(viable, txn)
+		}
+	}
+	return viable, conflicts
+}
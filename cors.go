@@ -0,0 +1,89 @@
+package walrus
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing, allowing browser-based
+// wallets served from other origins to access the API.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins (e.g. "https://wallet.example.com")
+	// permitted to access the API. The special value "*" permits any origin.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods permitted in cross-origin requests. If
+	// empty, all methods registered for the requested route are permitted.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers permitted in cross-origin
+	// requests. If empty, the Authorization and Content-Type headers are
+	// permitted.
+	AllowedHeaders []string
+	// MaxAge is the duration for which browsers may cache the result of a
+	// preflight request. If zero, browsers use their default.
+	MaxAge time.Duration
+}
+
+// CORS enables Cross-Origin Resource Sharing using the supplied options.
+func CORS(opts CORSOptions) ServerOption {
+	return func(s *server) {
+		s.cors = &opts
+	}
+}
+
+func (opts *CORSOptions) allowOrigin(origin string) (string, bool) {
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			return "*", true
+		} else if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// corsHandler wraps h, adding CORS headers to responses for permitted origins.
+func (s *server) corsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); origin != "" {
+			w.Header().Add("Vary", "Origin")
+			if allowed, ok := s.cors.allowOrigin(origin); ok {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join([]string{
+					NextCursorHeader,
+					ConsensusHeightHeader,
+					ConsensusIDHeader,
+				}, ", "))
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// corsPreflight handles preflight requests. It is called by the router for
+// OPTIONS requests to any registered route, after the router has set the
+// Allow header.
+func (s *server) corsPreflight(w http.ResponseWriter, req *http.Request) {
+	if w.Header().Get("Access-Control-Allow-Origin") == "" || req.Header.Get("Access-Control-Request-Method") == "" {
+		// not a (permitted) preflight request
+		return
+	}
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if len(s.cors.AllowedMethods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(s.cors.AllowedMethods, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Methods", h.Get("Allow"))
+	}
+	if len(s.cors.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowedHeaders, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	}
+	if s.cors.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
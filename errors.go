@@ -0,0 +1,129 @@
+package walrus
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// An ErrorCode identifies the kind of error returned by the walrus API.
+type ErrorCode string
+
+// Error codes.
+const (
+	// CodeBadRequest indicates a malformed or otherwise invalid request.
+	CodeBadRequest ErrorCode = "bad_request"
+	// CodeInvalidAddress indicates that an address could not be parsed.
+	CodeInvalidAddress ErrorCode = "invalid_address"
+	// CodeInvalidID indicates that a transaction or output ID could not be
+	// parsed.
+	CodeInvalidID ErrorCode = "invalid_id"
+	// CodeNotFound indicates that the requested object does not exist.
+	CodeNotFound ErrorCode = "not_found"
+	// CodeInsufficientFunds indicates that the wallet cannot fund a
+	// transaction.
+	CodeInsufficientFunds ErrorCode = "insufficient_funds"
+	// CodeInsufficientFee indicates that the transaction pool rejected a
+	// transaction set because its fee was too low.
+	CodeInsufficientFee ErrorCode = "insufficient_fee"
+	// CodeTpoolRejected indicates that the transaction pool rejected a
+	// transaction set for any other reason.
+	CodeTpoolRejected ErrorCode = "tpool_rejected"
+	// CodeOutputReserved indicates that an output is already reserved.
+	CodeOutputReserved ErrorCode = "output_reserved"
+	// CodeMemoTooLarge indicates that a memo exceeds the maximum size.
+	CodeMemoTooLarge ErrorCode = "memo_too_large"
+	// CodeUnauthorized indicates that the request lacked a valid credential.
+	CodeUnauthorized ErrorCode = "unauthorized"
+	// CodeForbidden indicates that the request's credential does not grant
+	// the required scope.
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeInternal indicates an unexpected server error.
+	CodeInternal ErrorCode = "internal"
+)
+
+// An Error is an error returned by the walrus API.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// StatusCode is the HTTP status of the response that contained the error.
+	StatusCode int `json:"-"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an *Error with the same Code, allowing errors
+// returned by the Client to be compared to the sentinel errors below via
+// errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Sentinel errors, for use with errors.Is. For example:
+//
+//	if errors.Is(err, walrus.ErrNotFound) {
+//	    // handle missing object
+//	}
+var (
+	ErrBadRequest        = &Error{Code: CodeBadRequest, Message: "bad request"}
+	ErrInvalidAddress    = &Error{Code: CodeInvalidAddress, Message: "invalid address"}
+	ErrInvalidID         = &Error{Code: CodeInvalidID, Message: "invalid ID"}
+	ErrNotFound          = &Error{Code: CodeNotFound, Message: "not found"}
+	ErrInsufficientFunds = &Error{Code: CodeInsufficientFunds, Message: "insufficient funds"}
+	ErrInsufficientFee   = &Error{Code: CodeInsufficientFee, Message: "insufficient fee"}
+	ErrTpoolRejected     = &Error{Code: CodeTpoolRejected, Message: "transaction pool rejected transaction set"}
+	ErrOutputReserved    = &Error{Code: CodeOutputReserved, Message: "output is already reserved"}
+	ErrMemoTooLarge      = &Error{Code: CodeMemoTooLarge, Message: "memo is too large"}
+	ErrUnauthorized      = &Error{Code: CodeUnauthorized, Message: "unauthorized"}
+	ErrForbidden         = &Error{Code: CodeForbidden, Message: "forbidden"}
+	ErrInternal          = &Error{Code: CodeInternal, Message: "internal error"}
+)
+
+// writeError writes a JSON-encoded Error to w.
+func writeError(w http.ResponseWriter, status int, code ErrorCode, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Code: code, Message: msg})
+}
+
+// isLowFeeError reports whether err was returned by the transaction pool
+// because a transaction set's fee was too low.
+//
+// NOTE: the transaction pool does not export this error, so we are forced to
+// compare strings.
+func isLowFeeError(err error) bool {
+	return strings.Contains(err.Error(), "needs more miner fees")
+}
+
+// decodeError returns the error contained in a non-200 response body. If the
+// body is not a JSON-encoded Error (e.g. because it was written by a proxy),
+// the code is inferred from the status.
+func decodeError(status int, body []byte) *Error {
+	var e Error
+	if err := json.Unmarshal(body, &e); err != nil || e.Code == "" {
+		e.Message = strings.TrimSpace(string(body))
+		switch status {
+		case http.StatusUnauthorized:
+			e.Code = CodeUnauthorized
+		case http.StatusForbidden:
+			e.Code = CodeForbidden
+		case http.StatusNotFound:
+			e.Code = CodeNotFound
+		case http.StatusConflict:
+			e.Code = CodeOutputReserved
+		default:
+			if status >= 500 {
+				e.Code = CodeInternal
+			} else {
+				e.Code = CodeBadRequest
+			}
+		}
+	}
+	e.StatusCode = status
+	return &e
+}
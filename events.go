@@ -0,0 +1,517 @@
+package walrus
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+// An EventType identifies the kind of change described by an Event.
+type EventType string
+
+// Event types.
+const (
+	// EventNewTransaction indicates that a relevant transaction that was not
+	// in Limbo (e.g. an incoming payment) appeared in a block.
+	EventNewTransaction EventType = "newTransaction"
+	// EventTransactionConfirmed indicates that a relevant transaction appeared
+	// in a block. It is emitted for every such transaction, including those
+	// that also trigger EventNewTransaction.
+	EventTransactionConfirmed EventType = "transactionConfirmed"
+	// EventLimboAdded indicates that a transaction was added to Limbo.
+	EventLimboAdded EventType = "limboAdded"
+	// EventLimboRemoved indicates that a transaction was removed from Limbo,
+	// either manually or because it appeared in a block.
+	EventLimboRemoved EventType = "limboRemoved"
+	// EventLimboConflict indicates that a transaction in Limbo was
+	// invalidated by a confirmed transaction spending the same inputs (or by
+	// the invalidation of one of its Limbo parents).
+	EventLimboConflict EventType = "limboConflict"
+	// EventBlockReward indicates that the wallet received a block reward.
+	EventBlockReward EventType = "blockReward"
+	// EventFileContract indicates that a relevant file contract was created
+	// or revised.
+	EventFileContract EventType = "fileContract"
+	// EventReorg indicates that one or more blocks were reverted.
+	EventReorg EventType = "reorg"
+)
+
+func validEventType(t EventType) bool {
+	switch t {
+	case EventNewTransaction, EventTransactionConfirmed, EventLimboAdded, EventLimboRemoved,
+		EventLimboConflict, EventBlockReward, EventFileContract, EventReorg:
+		return true
+	}
+	return false
+}
+
+// An Event describes a change to the wallet. Only the fields relevant to the
+// event's Type are set.
+type Event struct {
+	Type      EventType         `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Height    types.BlockHeight `json:"height"`
+	CCID      crypto.Hash       `json:"ccid"`
+
+	// set for transaction and Limbo events
+	TransactionID *types.TransactionID `json:"transactionID,omitempty"`
+	// set for EventNewTransaction and EventTransactionConfirmed
+	Transaction *ResponseTransactionsID `json:"transaction,omitempty"`
+	// set for EventLimboConflict
+	ConflictingID *types.TransactionID `json:"conflictingID,omitempty"`
+	// set for EventBlockReward
+	BlockReward *wallet.BlockReward `json:"blockReward,omitempty"`
+	// set for EventFileContract
+	FileContract *wallet.FileContract `json:"fileContract,omitempty"`
+	// set for EventReorg
+	RevertedBlocks       int                   `json:"revertedBlocks,omitempty"`
+	RevertedTransactions []types.TransactionID `json:"revertedTransactions,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e Event) MarshalJSON() ([]byte, error) {
+	// NOTE: to reuse the encodings of responseBlockRewards and
+	// responseFileContracts, we encode single-element slices and strip the
+	// surrounding brackets.
+	unwrap := func(js []byte) json.RawMessage { return js[1 : len(js)-1] }
+	var br, fc json.RawMessage
+	if e.BlockReward != nil {
+		js, _ := json.Marshal(responseBlockRewards{*e.BlockReward})
+		br = unwrap(js)
+	}
+	if e.FileContract != nil {
+		js, _ := json.Marshal(responseFileContracts{*e.FileContract})
+		fc = unwrap(js)
+	}
+	type encodedEvent Event
+	return json.Marshal(struct {
+		encodedEvent
+		BlockReward  json.RawMessage `json:"blockReward,omitempty"`
+		FileContract json.RawMessage `json:"fileContract,omitempty"`
+	}{encodedEvent(e), br, fc})
+}
+
+type eventSubscriber struct {
+	ch    chan Event
+	types map[EventType]struct{}
+}
+
+type eventListener struct {
+	fn func([]Event)
+}
+
+// DefaultMaxReverted is the default number of reverted transactions
+// remembered by an EventHub.
+const DefaultMaxReverted = 1000
+
+// EventHubOptions configures an EventHub.
+type EventHubOptions struct {
+	// RevertedPath is the path of the file storing transactions that were
+	// reverted and have not reappeared in a block. If empty, they are not
+	// persisted.
+	RevertedPath string
+	// MaxReverted is the maximum number of reverted transactions remembered;
+	// when it is exceeded, the transactions reverted least recently are
+	// forgotten. The default is DefaultMaxReverted.
+	MaxReverted int
+}
+
+// A revertedTransaction is a transaction that appeared in a block that was
+// subsequently reverted.
+type revertedTransaction struct {
+	Transaction wallet.Transaction `json:"transaction"`
+	RevertedAt  time.Time          `json:"revertedAt"`
+}
+
+// An EventHub watches a wallet for changes and broadcasts them to
+// subscribers.
+type EventHub struct {
+	w         *wallet.SeedWallet
+	opts      EventHubOptions
+	mu        sync.Mutex
+	subs      map[*eventSubscriber]struct{}
+	listeners map[*eventListener]struct{}
+	limbo     map[types.TransactionID]struct{}
+	reverted  map[types.TransactionID]revertedTransaction
+	conflicts map[types.TransactionID]types.TransactionID
+	spentBy   map[types.SiacoinOutputID]types.TransactionID
+	memos     *MemoStore
+	err       error
+}
+
+type eventHubSubscriber struct {
+	*EventHub
+	inner modules.ConsensusSetSubscriber
+}
+
+func (s eventHubSubscriber) ProcessConsensusChange(cc modules.ConsensusChange) {
+	height := s.w.ChainHeight()
+	s.inner.ProcessConsensusChange(cc)
+	s.processConsensusChange(cc, height)
+}
+
+// ConsensusSetSubscriber returns a modules.ConsensusSetSubscriber that passes
+// each ConsensusChange to inner (typically the subscriber returned by the
+// wallet's ConsensusSetSubscriber method) and then broadcasts the resulting
+// events. Subscribing inner directly would cause the EventHub to miss changes.
+func (h *EventHub) ConsensusSetSubscriber(inner modules.ConsensusSetSubscriber) modules.ConsensusSetSubscriber {
+	return eventHubSubscriber{
+		EventHub: h,
+		inner:    inner,
+	}
+}
+
+// Subscribe returns a channel that receives events of the specified types, or
+// of every type if none are specified, along with a function that cancels the
+// subscription. Slow subscribers are dropped: if the channel's buffer fills
+// up, the channel is closed.
+func (h *EventHub) Subscribe(types ...EventType) (<-chan Event, func()) {
+	sub := &eventSubscriber{
+		ch:    make(chan Event, 100),
+		types: make(map[EventType]struct{}),
+	}
+	for _, t := range types {
+		sub.types[t] = struct{}{}
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub.ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[sub]; ok {
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// listen registers fn to be called synchronously with each batch of events,
+// returning a function that unregisters it. Unlike subscribers, listeners are
+// never dropped; consequently, they must not block for long.
+func (h *EventHub) listen(fn func([]Event)) func() {
+	l := &eventListener{fn}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners[l] = struct{}{}
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.listeners, l)
+	}
+}
+
+// broadcast sends events to all listeners and interested subscribers. h.mu
+// must be held.
+func (h *EventHub) broadcast(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	for l := range h.listeners {
+		l.fn(events)
+	}
+	for sub := range h.subs {
+		for _, e := range events {
+			if _, ok := sub.types[e.Type]; !ok && len(sub.types) > 0 {
+				continue
+			}
+			select {
+			case sub.ch <- e:
+			default:
+				delete(h.subs, sub)
+				close(sub.ch)
+			}
+			if _, ok := h.subs[sub]; !ok {
+				break
+			}
+		}
+	}
+}
+
+func (h *EventHub) newEvent(t EventType) Event {
+	return Event{
+		Type:      t,
+		Timestamp: time.Now(),
+		Height:    h.w.ChainHeight(),
+		CCID:      crypto.Hash(h.w.ConsensusChangeID()),
+	}
+}
+
+// limboEvents updates h.limbo to match the wallet's Limbo, returning events
+// for any transactions added or removed. h.mu must be held.
+func (h *EventHub) limboEvents() []Event {
+	var events []Event
+	current := make(map[types.TransactionID]struct{})
+	for _, txn := range h.w.LimboTransactions() {
+		txid := txn.ID()
+		current[txid] = struct{}{}
+		if _, ok := h.limbo[txid]; !ok {
+			e := h.newEvent(EventLimboAdded)
+			e.TransactionID = &txid
+			events = append(events, e)
+		}
+	}
+	for txid := range h.limbo {
+		if _, ok := current[txid]; !ok {
+			txid := txid
+			e := h.newEvent(EventLimboRemoved)
+			e.TransactionID = &txid
+			events = append(events, e)
+		}
+	}
+	h.limbo = current
+	return events
+}
+
+// SyncLimbo broadcasts events for any transactions added to or removed from
+// the wallet's Limbo since the last call, and for any that conflict with a
+// confirmed transaction. It is called automatically by the walrus server and
+// after each ConsensusChange; it only needs to be called manually if Limbo is
+// modified by other means.
+func (h *EventHub) SyncLimbo() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.broadcast(append(h.limboEvents(), h.limboConflictEvents()...))
+}
+
+// limboConflictEvents returns events for any Limbo transactions that have
+// been invalidated since the last call, updating h.conflicts. h.mu must be
+// held.
+func (h *EventHub) limboConflictEvents() []Event {
+	conflicts := h.findConflicts(h.w.LimboTransactions())
+	var events []Event
+	for txid, conflict := range conflicts {
+		if prev, ok := h.conflicts[txid]; ok && prev == conflict {
+			continue
+		}
+		txid, conflict := txid, conflict
+		e := h.newEvent(EventLimboConflict)
+		e.TransactionID, e.ConflictingID = &txid, &conflict
+		events = append(events, e)
+	}
+	h.conflicts = conflicts
+	return events
+}
+
+// findConflicts returns the conflicts of the transactions in limbo, using
+// h.spentBy to look up confirmed spends. h.mu must be held.
+func (h *EventHub) findConflicts(limbo []wallet.LimboTransaction) map[types.TransactionID]types.TransactionID {
+	return findConflicts(limbo, func(sci types.SiacoinInput) (types.TransactionID, bool) {
+		txid, ok := h.spentBy[sci.ParentID]
+		return txid, ok
+	})
+}
+
+// limboConflicts returns the conflicts of the transactions in limbo. If limbo
+// matches the hub's view of Limbo, the cached conflicts are returned; the
+// returned map must not be modified.
+func (h *EventHub) limboConflicts(limbo []wallet.LimboTransaction) map[types.TransactionID]types.TransactionID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(limbo) == len(h.limbo) {
+		cached := true
+		for _, txn := range limbo {
+			if _, ok := h.limbo[txn.ID()]; !ok {
+				cached = false
+				break
+			}
+		}
+		if cached {
+			return h.conflicts
+		}
+	}
+	return h.findConflicts(limbo)
+}
+
+// updateSpends records the wallet-owned outputs spent by the transactions
+// applied in a ConsensusChange, and forgets those spent by the transactions
+// it reverted. h.mu must be held.
+func (h *EventHub) updateSpends(reverted, applied []wallet.Transaction) {
+	for _, txn := range reverted {
+		txid := txn.ID()
+		for _, sci := range txn.SiacoinInputs {
+			if h.spentBy[sci.ParentID] == txid {
+				delete(h.spentBy, sci.ParentID)
+			}
+		}
+	}
+	for _, txn := range applied {
+		h.addSpends(txn.ID(), txn.SiacoinInputs)
+	}
+}
+
+// addSpends records the wallet-owned outputs spent by inputs as spent by
+// txid. h.mu must be held.
+func (h *EventHub) addSpends(txid types.TransactionID, inputs []types.SiacoinInput) {
+	for _, sci := range inputs {
+		if h.w.OwnsAddress(sci.UnlockConditions.UnlockHash()) {
+			h.spentBy[sci.ParentID] = txid
+		}
+	}
+}
+
+// revertedTransaction returns the transaction with the specified ID if it was
+// reverted and has not been re-applied.
+func (h *EventHub) revertedTransaction(txid types.TransactionID) (wallet.Transaction, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rt, ok := h.reverted[txid]
+	return rt.Transaction, ok
+}
+
+// updateReverted records the transactions reverted by a ConsensusChange and
+// forgets those it applied, pruning and persisting the result. h.mu must be
+// held.
+func (h *EventHub) updateReverted(reverted, applied []wallet.Transaction) {
+	if len(reverted) == 0 && len(applied) == 0 {
+		return
+	}
+	// a reorg may revert a transaction and re-apply it in the same change, so
+	// reverted transactions must be added before applied ones are removed
+	var changed bool
+	now := time.Now()
+	for _, txn := range reverted {
+		h.reverted[txn.ID()] = revertedTransaction{txn, now}
+		changed = true
+	}
+	for _, txn := range applied {
+		if _, ok := h.reverted[txn.ID()]; ok {
+			delete(h.reverted, txn.ID())
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	rts := make([]revertedTransaction, 0, len(h.reverted))
+	for _, rt := range h.reverted {
+		rts = append(rts, rt)
+	}
+	sort.Slice(rts, func(i, j int) bool {
+		return rts[i].RevertedAt.After(rts[j].RevertedAt)
+	})
+	if len(rts) > h.opts.MaxReverted {
+		for _, rt := range rts[h.opts.MaxReverted:] {
+			delete(h.reverted, rt.Transaction.ID())
+		}
+		rts = rts[:h.opts.MaxReverted]
+	}
+	if h.opts.RevertedPath != "" {
+		if err := saveJSON(h.opts.RevertedPath, rts); err != nil {
+			h.err = err
+		}
+	}
+}
+
+// Err returns the most recent error encountered while persisting reverted
+// transactions, if any.
+func (h *EventHub) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// setMemos configures the EventHub to read the memos of transactions in its
+// events from ms, so that they match the server's responses.
+func (h *EventHub) setMemos(ms *MemoStore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.memos = ms
+}
+
+// processConsensusChange broadcasts events for cc. prevHeight is the wallet's
+// height prior to cc.
+func (h *EventHub) processConsensusChange(cc modules.ConsensusChange, prevHeight types.BlockHeight) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reverted, applied, _ := wallet.FilterConsensusChange(cc, h.w, prevHeight)
+	h.updateReverted(reverted.Transactions, applied.Transactions)
+	h.updateSpends(reverted.Transactions, applied.Transactions)
+
+	var events []Event
+	if len(cc.RevertedBlocks) > 0 {
+		e := h.newEvent(EventReorg)
+		e.RevertedBlocks = len(cc.RevertedBlocks)
+		for _, txn := range reverted.Transactions {
+			e.RevertedTransactions = append(e.RevertedTransactions, txn.ID())
+		}
+		events = append(events, e)
+	}
+	for _, txn := range applied.Transactions {
+		txid := txn.ID()
+		// use the wallet's copy, which has the correct height
+		wtxn, ok := h.w.Transaction(txid)
+		if !ok {
+			continue // reverted by a later block in the same change
+		}
+		resp := responseTransaction(wtxn, h.w, h.memos)
+		if _, ok := h.limbo[txid]; !ok {
+			e := h.newEvent(EventNewTransaction)
+			e.TransactionID, e.Transaction = &txid, &resp
+			events = append(events, e)
+		}
+		e := h.newEvent(EventTransactionConfirmed)
+		e.TransactionID, e.Transaction = &txid, &resp
+		events = append(events, e)
+	}
+	for i := range applied.BlockRewards {
+		e := h.newEvent(EventBlockReward)
+		e.BlockReward = &applied.BlockRewards[i]
+		events = append(events, e)
+	}
+	for i := range applied.FileContracts {
+		e := h.newEvent(EventFileContract)
+		e.FileContract = &applied.FileContracts[i]
+		events = append(events, e)
+	}
+	events = append(events, h.limboEvents()...)
+	events = append(events, h.limboConflictEvents()...)
+	h.broadcast(events)
+}
+
+// NewEventHub returns an EventHub that watches the supplied wallet. To
+// receive consensus events, the hub must be subscribed to the consensus set
+// via its ConsensusSetSubscriber method. If opts.RevertedPath refers to an
+// existing file, the reverted transactions it contains are loaded.
+func NewEventHub(w *wallet.SeedWallet, opts EventHubOptions) (*EventHub, error) {
+	if opts.MaxReverted == 0 {
+		opts.MaxReverted = DefaultMaxReverted
+	}
+	h := &EventHub{
+		w:         w,
+		opts:      opts,
+		subs:      make(map[*eventSubscriber]struct{}),
+		listeners: make(map[*eventListener]struct{}),
+		limbo:     make(map[types.TransactionID]struct{}),
+		reverted:  make(map[types.TransactionID]revertedTransaction),
+		spentBy:   make(map[types.SiacoinOutputID]types.TransactionID),
+	}
+	if opts.RevertedPath != "" {
+		var rts []revertedTransaction
+		if err := loadJSON(opts.RevertedPath, &rts); err != nil {
+			return nil, err
+		}
+		for _, rt := range rts {
+			h.reverted[rt.Transaction.ID()] = rt
+		}
+	}
+	// index the wallet's history once; thereafter, h.spentBy is updated by
+	// each ConsensusChange
+	for _, txid := range w.Transactions(-1) {
+		if txn, ok := w.Transaction(txid); ok {
+			h.addSpends(txid, txn.SiacoinInputs)
+		}
+	}
+	limbo := w.LimboTransactions()
+	for _, txn := range limbo {
+		h.limbo[txn.ID()] = struct{}{}
+	}
+	h.conflicts = h.findConflicts(limbo)
+	return h, nil
+}
@@ -0,0 +1,250 @@
+package walrus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/types"
+)
+
+// An endpointSet is the set of servers that a Client may send requests to.
+// It is shared by copies of the Client (see WithContext).
+type endpointSet struct {
+	addrs []string
+
+	mu  sync.Mutex
+	cur int // index of the most recently successful endpoint
+}
+
+// order returns the endpoints in the order they should be tried, beginning
+// with the most recently successful endpoint.
+func (es *endpointSet) order() []int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	order := make([]int, len(es.addrs))
+	for i := range order {
+		order[i] = (es.cur + i) % len(es.addrs)
+	}
+	return order
+}
+
+func (es *endpointSet) setCurrent(i int) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.cur = i
+}
+
+// WithRetries configures the Client to retry idempotent (GET) requests up to n
+// times if every endpoint fails with a connection error or a 502, 503, or 504
+// status. The Client waits for backoff before the first retry, doubling the
+// delay before each subsequent retry.
+func WithRetries(n int, backoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// WithConsensusCheck configures a multi-endpoint Client to verify that all
+// other reachable endpoints agree with the consensus state that a balance was
+// computed at (see CheckConsensus) before returning it. This guards against
+// trusting a server that has fallen behind the others.
+func WithConsensusCheck() ClientOption {
+	return func(c *Client) {
+		c.checkConsensus = true
+	}
+}
+
+// isDialError reports whether err occurred while connecting to a server, in
+// which case the request was certainly not received.
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// isRetryableStatus reports whether a response with the specified status
+// should be retried on another endpoint.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// doEndpoint performs a request against a single endpoint.
+func (c *Client) doEndpoint(addr, method, route string, body []byte, header http.Header) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, addr+route, r)
+	if err != nil {
+		panic(err)
+	}
+	for k, v := range c.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.setAuth(req)
+	return c.hc.Do(req)
+}
+
+// do performs a request, failing over to other endpoints and retrying as
+// configured. GET requests fail over on any connection error or retryable
+// status, whereas other requests only fail over if the connection could not be
+// established, since the server may otherwise have acted on the request.
+func (c *Client) do(method, route string, body []byte, header http.Header) (*http.Response, error) {
+	resp, _, err := c.doEndpoints(method, route, body, header)
+	return resp, err
+}
+
+// doEndpoints is like do, but also returns the endpoint that served the
+// request.
+func (c *Client) doEndpoints(method, route string, body []byte, header http.Header) (*http.Response, string, error) {
+	idempotent := method == "GET"
+	retries := c.retries
+	if !idempotent {
+		retries = 0
+	}
+	backoff := c.backoff
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(backoff)
+			select {
+			case <-c.ctx.Done():
+				t.Stop()
+				return nil, "", c.ctx.Err()
+			case <-t.C:
+			}
+			backoff *= 2
+		}
+		order := c.eps.order()
+		for n, i := range order {
+			resp, err := c.doEndpoint(c.eps.addrs[i], method, route, body, header)
+			last := attempt == retries && n == len(order)-1
+			if err != nil {
+				if c.ctx.Err() != nil {
+					return nil, "", err
+				} else if !idempotent && !isDialError(err) {
+					return nil, "", err
+				}
+				lastErr = err
+				continue
+			} else if idempotent && isRetryableStatus(resp.StatusCode) && !last {
+				resp.Body.Close()
+				lastErr = fmt.Errorf("%v returned status %v", c.eps.addrs[i], resp.StatusCode)
+				continue
+			}
+			c.eps.setCurrent(i)
+			return resp, c.eps.addrs[i], nil
+		}
+	}
+	return nil, "", lastErr
+}
+
+// A ConsensusMismatchError is returned when the endpoints of a multi-endpoint
+// Client disagree on the current consensus state.
+type ConsensusMismatchError struct {
+	// Infos maps each reachable endpoint to its reported consensus state.
+	Infos map[string]ResponseConsensus
+}
+
+// Error implements error.
+func (e *ConsensusMismatchError) Error() string {
+	strs := make([]string, 0, len(e.Infos))
+	for addr, info := range e.Infos {
+		strs = append(strs, fmt.Sprintf("%v (height %v, ccid %v)", addr, info.Height, info.CCID))
+	}
+	sort.Strings(strs)
+	return "endpoints disagree on consensus state: " + strings.Join(strs, ", ")
+}
+
+// CheckConsensus queries the consensus state of every endpoint, returning the
+// agreed state, or an error if the reachable endpoints disagree or no endpoint
+// is reachable. Unreachable endpoints are ignored. For a single-endpoint
+// Client, it is equivalent to ConsensusInfo.
+func (c *Client) CheckConsensus() (ResponseConsensus, error) {
+	return c.agreedConsensus(make(map[string]ResponseConsensus))
+}
+
+// agreedConsensus is like CheckConsensus, but does not query the endpoints
+// whose consensus state is already present in infos.
+func (c *Client) agreedConsensus(infos map[string]ResponseConsensus) (ResponseConsensus, error) {
+	var lastErr error
+	for _, addr := range c.eps.addrs {
+		if _, ok := infos[addr]; ok {
+			continue
+		}
+		c2 := *c
+		c2.eps = &endpointSet{addrs: []string{addr}}
+		c2.retries = 0
+		info, err := c2.ConsensusInfo()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		infos[addr] = info
+	}
+	if len(infos) == 0 {
+		return ResponseConsensus{}, lastErr
+	}
+	var first ResponseConsensus
+	for _, info := range infos {
+		first = info
+		break
+	}
+	for _, info := range infos {
+		if info != first {
+			return ResponseConsensus{}, &ConsensusMismatchError{Infos: infos}
+		}
+	}
+	return first, nil
+}
+
+// balance returns the balance reported by route. If the Client was configured
+// with WithConsensusCheck and has multiple endpoints, the balance is rejected
+// unless the other reachable endpoints agree with the consensus state that
+// the serving endpoint computed it at.
+func (c *Client) balance(route string) (bal types.Currency, err error) {
+	h, addr, err := c.reqEndpoint("GET", route, nil, &bal)
+	if err != nil || !c.checkConsensus || len(c.eps.addrs) < 2 {
+		return bal, err
+	}
+	var info ResponseConsensus
+	height, err := strconv.ParseUint(h.Get(ConsensusHeightHeader), 10, 64)
+	if err != nil || info.CCID.LoadString(h.Get(ConsensusIDHeader)) != nil {
+		return types.Currency{}, errors.New("server did not report the consensus state of its balance")
+	}
+	info.Height = types.BlockHeight(height)
+	if _, err := c.agreedConsensus(map[string]ResponseConsensus{addr: info}); err != nil {
+		return types.Currency{}, err
+	}
+	return bal, nil
+}
+
+// NewMultiClient returns a client that communicates with a set of redundant
+// walrus servers. Requests are sent to one server at a time, beginning with
+// the first; if a server cannot be reached, the client fails over to the next
+// server, and continues to use it for subsequent requests. The servers should
+// track the same set of addresses.
+func NewMultiClient(addrs []string, opts ...ClientOption) *Client {
+	if len(addrs) == 0 {
+		panic("no endpoints specified")
+	}
+	norm := make([]string, len(addrs))
+	for i, addr := range addrs {
+		norm[i] = normalizeAddr(addr)
+	}
+	return newClient(norm, opts)
+}
@@ -0,0 +1,144 @@
+package walrus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+func TestClientFailover(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	var hits int32
+	live := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		NewServer(w, stubTpool{}).ServeHTTP(rw, req)
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// requests should fail over to the live server, and continue to use it
+	client := NewMultiClient([]string{dead.URL, live.URL})
+	for i := 0; i < 3; i++ {
+		if _, err := client.Balance(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != 3 {
+		t.Fatal("expected 3 requests to live server, got", hits)
+	} else if client.eps.cur != 1 {
+		t.Fatal("client did not switch endpoints")
+	}
+
+	// non-idempotent requests should also fail over if the connection was
+	// refused
+	client = NewMultiClient([]string{dead.URL, live.URL})
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	if err := client.AddAddress(info); err != nil {
+		t.Fatal(err)
+	} else if _, ok := w.AddressInfo(info.UnlockHash()); !ok {
+		t.Fatal("address was not added")
+	}
+
+	// if every server is down, the connection error should be returned
+	client = NewMultiClient([]string{dead.URL, dead.URL})
+	if _, err := client.Balance(false); err == nil || !isDialError(err) {
+		t.Fatal("expected dial error, got", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	var failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		NewServer(w, stubTpool{}).ServeHTTP(rw, req)
+	}))
+	defer srv.Close()
+
+	// without retries, the error should be returned
+	atomic.StoreInt32(&failures, 2)
+	if _, err := NewClient(srv.URL).Balance(false); !errors.Is(err, ErrInternal) {
+		t.Fatal("expected internal error, got", err)
+	}
+
+	// with enough retries, the request should succeed
+	atomic.StoreInt32(&failures, 2)
+	client := NewClient(srv.URL, WithRetries(2, time.Millisecond))
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// non-idempotent requests should not be retried
+	atomic.StoreInt32(&failures, 1)
+	if err := client.Broadcast([]types.Transaction{{}}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestClientConsensusCheck(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	var hits int32
+	newServer := func(height *types.BlockHeight) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			if req.URL.Path == "/consensus" {
+				writeJSON(rw, ResponseConsensus{Height: *height})
+				return
+			}
+			NewServer(w, stubTpool{}).ServeHTTP(rw, req)
+		}))
+	}
+	var height1, height2 types.BlockHeight
+	srv1, srv2 := newServer(&height1), newServer(&height2)
+	defer srv1.Close()
+	defer srv2.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// unreachable endpoints should be ignored
+	client := NewMultiClient([]string{srv1.URL, srv2.URL, dead.URL}, WithConsensusCheck())
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if hits != 2 {
+		// the serving endpoint reports its consensus state alongside the
+		// balance, so it should not be queried separately
+		t.Fatal("expected 2 requests, got", hits)
+	}
+
+	// the balance should be checked against the state it was computed at, not
+	// the serving endpoint's current state
+	height1, height2 = 1, 1
+	if _, err := client.Balance(false); err == nil {
+		t.Fatal("expected consensus mismatch")
+	}
+	height1 = 0
+
+	// a mismatch should prevent the balance from being returned
+	height2 = 1
+	_, err := client.Balance(false)
+	if me, ok := err.(*ConsensusMismatchError); !ok {
+		t.Fatal("expected consensus mismatch, got", err)
+	} else if len(me.Infos) != 2 || me.Infos[srv2.URL].Height != 1 {
+		t.Fatal("wrong mismatch info:", me.Infos)
+	}
+
+	// without the option, balances are returned regardless
+	client = NewMultiClient([]string{srv1.URL, srv2.URL})
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if _, err := client.CheckConsensus(); err == nil {
+		t.Fatal("expected consensus mismatch")
+	}
+}
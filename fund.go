@@ -0,0 +1,393 @@
+package walrus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+	"lukechampine.com/frand"
+	"lukechampine.com/us/wallet"
+)
+
+// maxCurrency is an upper bound on any Currency appearing in a transaction,
+// used when estimating the encoded size of a transaction whose change and fee
+// values are not yet known.
+var maxCurrency = types.NewCurrency(new(big.Int).Lsh(big.NewInt(1), 127))
+
+// bytesPerInput is the encoded size of a SiacoinInput and corresponding
+// TransactionSignature, assuming standard UnlockConditions.
+//
+// NOTE: wallet.BytesPerInput underestimates this size.
+var bytesPerInput = func() uint64 {
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			UnlockConditions: wallet.StandardUnlockConditions(types.Ed25519PublicKey(crypto.PublicKey{})),
+		}},
+		TransactionSignatures: []types.TransactionSignature{
+			wallet.StandardTransactionSignature(crypto.Hash{}),
+		},
+	}
+	txn.TransactionSignatures[0].Signature = make([]byte, crypto.SignatureSize)
+	return uint64(txn.MarshalSiaSize() - (types.Transaction{}).MarshalSiaSize())
+}()
+
+// estimateSize returns an upper bound on the encoded size of txn after adding
+// numInputs standard inputs (and their signatures), a change output, and a
+// miner fee.
+func estimateSize(txn types.Transaction, numInputs int) uint64 {
+	txn.SiacoinOutputs = append(txn.SiacoinOutputs[:len(txn.SiacoinOutputs):len(txn.SiacoinOutputs)], types.SiacoinOutput{Value: maxCurrency})
+	txn.MinerFees = append(txn.MinerFees[:len(txn.MinerFees):len(txn.MinerFees)], maxCurrency)
+	return uint64(txn.MarshalSiaSize()) + uint64(numInputs)*bytesPerInput
+}
+
+// spendableInputs returns the wallet's confirmed outputs that are neither
+// spent by any viable Limbo transaction nor reserved. s.res.mu must be held.
+func (s *server) spendableInputs() []wallet.ValuedInput {
+	spent := make(map[types.SiacoinOutputID]struct{})
+	viable, _ := s.viableLimbo()
+	for _, txn := range viable {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.ParentID] = struct{}{}
+		}
+	}
+	inputs := s.w.ValuedInputs()
+	spendable := inputs[:0]
+	for _, in := range inputs {
+		if _, ok := spent[in.ParentID]; !ok && !s.res.reservedLocked(in.ParentID) {
+			spendable = append(spendable, in)
+		}
+	}
+	return spendable
+}
+
+// controlledInputs returns the spendable inputs permitted by cc. Any inputs
+// that cc requires to be spent are returned first, followed by the remaining
+// inputs in random order. s.res.mu must be held.
+func (s *server) controlledInputs(cc CoinControl) (inputs []wallet.ValuedInput, required int, err error) {
+	spendable := s.spendableInputs()
+	frand.Shuffle(len(spendable), reflect.Swapper(spendable))
+	byID := make(map[types.SiacoinOutputID]wallet.ValuedInput, len(spendable))
+	for _, in := range spendable {
+		byID[in.ParentID] = in
+	}
+	excluded := make(map[types.SiacoinOutputID]struct{})
+	for _, id := range cc.Exclude {
+		excluded[id] = struct{}{}
+	}
+	included := make(map[types.SiacoinOutputID]struct{})
+	for _, id := range cc.Include {
+		in, ok := byID[id]
+		if !ok {
+			return nil, 0, fmt.Errorf("output %v is not spendable", id)
+		} else if _, ok := excluded[id]; ok {
+			return nil, 0, fmt.Errorf("output %v is both included and excluded", id)
+		} else if _, ok := included[id]; ok {
+			continue // duplicate
+		}
+		inputs = append(inputs, in)
+		included[id] = struct{}{}
+	}
+	required = len(inputs)
+	if cc.IncludeOnly {
+		return inputs, required, nil
+	}
+	for _, in := range spendable {
+		_, inc := included[in.ParentID]
+		_, exc := excluded[in.ParentID]
+		if !inc && !exc {
+			inputs = append(inputs, in)
+		}
+	}
+	return inputs, required, nil
+}
+
+// fundAtLeast is like wallet.FundAtLeast, but always uses at least the first
+// required inputs.
+func fundAtLeast(amount types.Currency, inputs []wallet.ValuedInput, required int) ([]wallet.ValuedInput, types.Currency, bool) {
+	used, change, ok := wallet.FundAtLeast(amount, inputs)
+	if ok && len(used) < required {
+		used = inputs[:required]
+		var sum types.Currency
+		for _, in := range used {
+			sum = sum.Add(in.Value)
+		}
+		change = sum.Sub(amount)
+	}
+	return used, change, ok
+}
+
+// fundParams are the parameters of fundTransaction.
+type fundParams struct {
+	feePerByte types.Currency
+	changeAddr *types.UnlockHash
+	reserve    time.Duration
+	cc         CoinControl
+}
+
+// fundTransaction adds inputs to txn sufficient to cover its outputs, along
+// with a miner fee of p.feePerByte times the transaction's encoded size. Any
+// excess value is sent to p.changeAddr, or to the address of the first input
+// if p.changeAddr is nil. If p.reserve is non-zero, the inputs are reserved
+// for that duration.
+func (s *server) fundTransaction(txn types.Transaction, p fundParams) (ResponseTxnFund, error) {
+	// hold the lock throughout, so that concurrent requests cannot select the
+	// same inputs
+	s.res.mu.Lock()
+	defer s.res.mu.Unlock()
+
+	var amount types.Currency
+	for _, sco := range txn.SiacoinOutputs {
+		amount = amount.Add(sco.Value)
+	}
+	for _, fee := range txn.MinerFees {
+		amount = amount.Add(fee)
+	}
+	inputs, required, err := s.controlledInputs(p.cc)
+	if err != nil {
+		return ResponseTxnFund{}, err
+	}
+
+	// the fee depends on the number of inputs, which depends on the fee;
+	// iterate until the number of inputs stabilizes
+	used, _, ok := fundAtLeast(amount, inputs, required)
+	if !ok {
+		return ResponseTxnFund{}, wallet.ErrInsufficientFunds
+	}
+	var fee, change types.Currency
+	for {
+		numInputs := len(used)
+		fee = p.feePerByte.Mul64(estimateSize(txn, numInputs))
+		used, change, ok = fundAtLeast(amount.Add(fee), inputs, required)
+		if !ok {
+			return ResponseTxnFund{}, wallet.ErrInsufficientFunds
+		} else if len(used) == numInputs {
+			break
+		}
+	}
+	if len(used) == 0 {
+		return ResponseTxnFund{}, errors.New("transaction has no value")
+	}
+
+	changeAddr := p.changeAddr
+	if changeAddr == nil {
+		addr := used[0].UnlockConditions.UnlockHash()
+		changeAddr = &addr
+	}
+	if !change.IsZero() {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+			UnlockHash: *changeAddr,
+			Value:      change,
+		})
+	}
+	if !fee.IsZero() {
+		txn.MinerFees = append(txn.MinerFees, fee)
+	}
+	resp, err := s.addInputs(txn, used, fee, change)
+	if err == nil && p.reserve > 0 {
+		ids := make([]types.SiacoinOutputID, len(used))
+		for i := range used {
+			ids[i] = used[i].ParentID
+		}
+		resp.ReservationToken, err = s.res.reserveLocked(ids, time.Now().Add(p.reserve))
+	}
+	return resp, err
+}
+
+// consolidateParams are the parameters of consolidate.
+type consolidateParams struct {
+	addr       types.UnlockHash
+	addrs      []types.UnlockHash
+	maxValue   types.Currency
+	maxSize    uint64
+	feePerByte types.Currency
+	reserve    time.Duration
+}
+
+// consolidate returns a series of transactions that each merge as many of the
+// wallet's smallest spendable outputs as will fit in p.maxSize bytes into a
+// single output sent to p.addr. Outputs worth less than the fee required to
+// spend them are ignored. If p.reserve is non-zero, the inputs are reserved
+// for that duration.
+func (s *server) consolidate(p consolidateParams) ([]ResponseTxnFund, error) {
+	s.res.mu.Lock()
+	defer s.res.mu.Unlock()
+
+	if p.maxSize < estimateSize(types.Transaction{}, 2) {
+		return nil, errors.New("maximum size is too small")
+	}
+	maxInputs := int((p.maxSize - estimateSize(types.Transaction{}, 0)) / bytesPerInput)
+
+	addrs := make(map[types.UnlockHash]struct{}, len(p.addrs))
+	for _, addr := range p.addrs {
+		addrs[addr] = struct{}{}
+	}
+	dust := p.feePerByte.Mul64(bytesPerInput)
+	var inputs []wallet.ValuedInput
+	for _, in := range s.spendableInputs() {
+		if _, ok := addrs[in.UnlockConditions.UnlockHash()]; len(addrs) > 0 && !ok {
+			continue
+		} else if !p.maxValue.IsZero() && in.Value.Cmp(p.maxValue) > 0 {
+			continue
+		} else if in.Value.Cmp(dust) <= 0 {
+			continue
+		}
+		inputs = append(inputs, in)
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		if c := inputs[i].Value.Cmp(inputs[j].Value); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(inputs[i].ParentID[:], inputs[j].ParentID[:]) < 0
+	})
+
+	var txns []ResponseTxnFund
+	var ids []types.SiacoinOutputID
+	for len(inputs) >= 2 {
+		n := maxInputs
+		if n > len(inputs) {
+			n = len(inputs)
+		}
+		batch := inputs[:n]
+		inputs = inputs[n:]
+		var sum types.Currency
+		for _, in := range batch {
+			sum = sum.Add(in.Value)
+		}
+		fee := p.feePerByte.Mul64(estimateSize(types.Transaction{}, n))
+		if sum.Cmp(fee) <= 0 {
+			continue
+		}
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{
+				UnlockHash: p.addr,
+				Value:      sum.Sub(fee),
+			}},
+		}
+		if !fee.IsZero() {
+			txn.MinerFees = []types.Currency{fee}
+		}
+		resp, err := s.addInputs(txn, batch, fee, types.ZeroCurrency)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, resp)
+		for _, in := range batch {
+			ids = append(ids, in.ParentID)
+		}
+	}
+	if len(ids) > 0 && p.reserve > 0 {
+		token, err := s.res.reserveLocked(ids, time.Now().Add(p.reserve))
+		if err != nil {
+			return nil, err
+		}
+		for i := range txns {
+			txns[i].ReservationToken = token
+		}
+	}
+	return txns, nil
+}
+
+// sweepParams are the parameters of sweep.
+type sweepParams struct {
+	addr       types.UnlockHash
+	addrs      []types.UnlockHash
+	feePerByte types.Currency
+	reserve    time.Duration
+	cc         CoinControl
+}
+
+// sweep returns a transaction that sends the value of every spendable output
+// permitted by p.cc and p.addrs, minus a miner fee, to p.addr. Outputs worth
+// less than the fee required to spend them are ignored unless p.cc requires
+// them. If p.reserve is non-zero, the inputs are reserved for that duration.
+func (s *server) sweep(p sweepParams) (ResponseTxnFund, error) {
+	s.res.mu.Lock()
+	defer s.res.mu.Unlock()
+
+	inputs, required, err := s.controlledInputs(p.cc)
+	if err != nil {
+		return ResponseTxnFund{}, err
+	}
+	addrs := make(map[types.UnlockHash]struct{}, len(p.addrs))
+	for _, addr := range p.addrs {
+		addrs[addr] = struct{}{}
+	}
+	dust := p.feePerByte.Mul64(bytesPerInput)
+	used := inputs[:required:required]
+	var sum types.Currency
+	for _, in := range used {
+		sum = sum.Add(in.Value)
+	}
+	for _, in := range inputs[required:] {
+		if _, ok := addrs[in.UnlockConditions.UnlockHash()]; len(addrs) > 0 && !ok {
+			continue
+		} else if in.Value.Cmp(dust) <= 0 {
+			continue
+		}
+		used = append(used, in)
+		sum = sum.Add(in.Value)
+	}
+
+	size := estimateSize(types.Transaction{}, len(used))
+	if size > modules.TransactionSizeLimit {
+		return ResponseTxnFund{}, fmt.Errorf("transaction would spend %v outputs, exceeding the size limit; consolidate outputs first", len(used))
+	}
+	fee := p.feePerByte.Mul64(size)
+	if len(used) == 0 || sum.Cmp(fee) <= 0 {
+		return ResponseTxnFund{}, wallet.ErrInsufficientFunds
+	}
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{
+			UnlockHash: p.addr,
+			Value:      sum.Sub(fee),
+		}},
+	}
+	if !fee.IsZero() {
+		txn.MinerFees = []types.Currency{fee}
+	}
+	resp, err := s.addInputs(txn, used, fee, types.ZeroCurrency)
+	if err == nil && p.reserve > 0 {
+		ids := make([]types.SiacoinOutputID, len(used))
+		for i := range used {
+			ids[i] = used[i].ParentID
+		}
+		resp.ReservationToken, err = s.res.reserveLocked(ids, time.Now().Add(p.reserve))
+	}
+	return resp, err
+}
+
+// addInputs adds the supplied inputs to txn, along with the signatures
+// required to spend them.
+func (s *server) addInputs(txn types.Transaction, inputs []wallet.ValuedInput, fee, change types.Currency) (ResponseTxnFund, error) {
+	resp := ResponseTxnFund{
+		Fee:    fee,
+		Change: change,
+	}
+	for _, in := range inputs {
+		addr := in.UnlockConditions.UnlockHash()
+		info, ok := s.w.AddressInfo(addr)
+		if !ok {
+			return ResponseTxnFund{}, errors.New("missing address info for " + addr.String())
+		}
+		txn.SiacoinInputs = append(txn.SiacoinInputs, in.SiacoinInput)
+		txn.TransactionSignatures = append(txn.TransactionSignatures, wallet.StandardTransactionSignature(crypto.Hash(in.ParentID)))
+		resp.ToSign = append(resp.ToSign, RequiredSignature{
+			ParentID: crypto.Hash(in.ParentID),
+			SigIndex: len(txn.TransactionSignatures) - 1,
+			KeyIndex: info.KeyIndex,
+		})
+	}
+	// sighashes must be computed after the transaction is finalized
+	for i := range resp.ToSign {
+		resp.ToSign[i].SigHash = txn.SigHash(resp.ToSign[i].SigIndex, types.FoundationHardforkHeight+1)
+	}
+	resp.Transaction = txn
+	return resp, nil
+}
@@ -0,0 +1,112 @@
+package walrus
+
+import (
+	"net/url"
+	"strconv"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+func pageQuery(cursor string, limit int) url.Values {
+	v := make(url.Values)
+	if cursor != "" {
+		v.Set("cursor", cursor)
+	}
+	if limit >= 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	return v
+}
+
+// A TransactionIterator iterates over pages of transaction IDs. Typical usage:
+//
+//	it := c.TransactionIterator(walrus.TransactionQuery{Limit: 100})
+//	for it.Next() {
+//	    for _, txid := range it.Page() {
+//	        // ...
+//	    }
+//	}
+//	if err := it.Err(); err != nil {
+//	    // ...
+//	}
+type TransactionIterator struct {
+	c    *Client
+	q    TransactionQuery
+	page []types.TransactionID
+	err  error
+	done bool
+}
+
+// Next fetches the next page, returning false if no pages remain or an error
+// occurred.
+func (it *TransactionIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	it.page, it.q.Cursor, it.err = it.c.TransactionsPage(it.q)
+	it.done = it.q.Cursor == ""
+	return it.err == nil && len(it.page) > 0
+}
+
+// Page returns the current page.
+func (it *TransactionIterator) Page() []types.TransactionID { return it.page }
+
+// Err returns the error that terminated iteration, if any.
+func (it *TransactionIterator) Err() error { return it.err }
+
+// A BlockRewardIterator iterates over pages of block rewards. Its usage is
+// identical to TransactionIterator.
+type BlockRewardIterator struct {
+	c        *Client
+	pageSize int
+	cursor   string
+	page     []wallet.BlockReward
+	err      error
+	done     bool
+}
+
+// Next fetches the next page, returning false if no pages remain or an error
+// occurred.
+func (it *BlockRewardIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	it.page, it.cursor, it.err = it.c.BlockRewardsPage(it.cursor, it.pageSize)
+	it.done = it.cursor == ""
+	return it.err == nil && len(it.page) > 0
+}
+
+// Page returns the current page.
+func (it *BlockRewardIterator) Page() []wallet.BlockReward { return it.page }
+
+// Err returns the error that terminated iteration, if any.
+func (it *BlockRewardIterator) Err() error { return it.err }
+
+// A FileContractIterator iterates over pages of file contracts. Its usage is
+// identical to TransactionIterator.
+type FileContractIterator struct {
+	c        *Client
+	pageSize int
+	cursor   string
+	page     []wallet.FileContract
+	err      error
+	done     bool
+}
+
+// Next fetches the next page, returning false if no pages remain or an error
+// occurred.
+func (it *FileContractIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	it.page, it.cursor, it.err = it.c.FileContractsPage(it.cursor, it.pageSize)
+	it.done = it.cursor == ""
+	return it.err == nil && len(it.page) > 0
+}
+
+// Page returns the current page.
+func (it *FileContractIterator) Page() []wallet.FileContract { return it.page }
+
+// Err returns the error that terminated iteration, if any.
+func (it *FileContractIterator) Err() error { return it.err }
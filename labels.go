@@ -0,0 +1,157 @@
+package walrus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.sia.tech/siad/types"
+)
+
+// maxAddressMetadataSize is the maximum size of the JSON metadata attached to
+// an address.
+const maxAddressMetadataSize = 16 << 10
+
+// AddressMetadata is user-defined information attached to an address, such as
+// the customer it was issued to.
+type AddressMetadata struct {
+	Label string   `json:"label,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Metadata is an arbitrary JSON value.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// IsEmpty reports whether md contains no information.
+func (md AddressMetadata) IsEmpty() bool {
+	return md.Label == "" && len(md.Tags) == 0 && len(md.Metadata) == 0
+}
+
+// HasTags reports whether md contains all of the specified tags.
+func (md AddressMetadata) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		i := sort.SearchStrings(md.Tags, tag)
+		if i == len(md.Tags) || md.Tags[i] != tag {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize validates md, sorting and deduplicating its tags.
+func (md AddressMetadata) normalize() (AddressMetadata, error) {
+	if len(md.Metadata) > maxAddressMetadataSize {
+		return AddressMetadata{}, fmt.Errorf("metadata exceeds maximum size of %v bytes", maxAddressMetadataSize)
+	} else if len(md.Metadata) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, md.Metadata); err != nil {
+			return AddressMetadata{}, errors.New("metadata is not valid JSON")
+		}
+		md.Metadata = buf.Bytes()
+		if string(md.Metadata) == "null" {
+			md.Metadata = nil
+		}
+	}
+	tags := make([]string, 0, len(md.Tags))
+	for _, tag := range md.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return AddressMetadata{}, errors.New("tags must be non-empty")
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	md.Tags = nil
+	for i, tag := range tags {
+		if i == 0 || tag != tags[i-1] {
+			md.Tags = append(md.Tags, tag)
+		}
+	}
+	return md, nil
+}
+
+// LabelOptions configures a LabelStore.
+type LabelOptions struct {
+	// Path is the path of the file storing address metadata. If empty,
+	// metadata is not persisted.
+	Path string
+}
+
+// A LabelStore stores the metadata attached to addresses.
+type LabelStore struct {
+	opts LabelOptions
+
+	mu sync.Mutex
+	m  map[types.UnlockHash]AddressMetadata
+}
+
+// Metadata returns the metadata attached to addr.
+func (ls *LabelStore) Metadata(addr types.UnlockHash) AddressMetadata {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.m[addr]
+}
+
+// SetMetadata attaches md to addr, replacing any existing metadata.
+func (ls *LabelStore) SetMetadata(addr types.UnlockHash, md AddressMetadata) error {
+	md, err := md.normalize()
+	if err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if md.IsEmpty() {
+		delete(ls.m, addr)
+	} else {
+		ls.m[addr] = md
+	}
+	return ls.save()
+}
+
+// DeleteMetadata removes any metadata attached to addr.
+func (ls *LabelStore) DeleteMetadata(addr types.UnlockHash) error {
+	return ls.SetMetadata(addr, AddressMetadata{})
+}
+
+// persistedLabel is the on-disk representation of an address's metadata.
+type persistedLabel struct {
+	Address types.UnlockHash `json:"address"`
+	AddressMetadata
+}
+
+// save persists the store. ls.mu must be held.
+func (ls *LabelStore) save() error {
+	if ls.opts.Path == "" {
+		return nil
+	}
+	labels := make([]persistedLabel, 0, len(ls.m))
+	for addr, md := range ls.m {
+		labels = append(labels, persistedLabel{addr, md})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Address.String() < labels[j].Address.String()
+	})
+	return saveJSON(ls.opts.Path, labels)
+}
+
+// NewLabelStore returns a LabelStore, loading any metadata stored at
+// opts.Path.
+func NewLabelStore(opts LabelOptions) (*LabelStore, error) {
+	ls := &LabelStore{
+		opts: opts,
+		m:    make(map[types.UnlockHash]AddressMetadata),
+	}
+	if opts.Path != "" {
+		var labels []persistedLabel
+		if err := loadJSON(opts.Path, &labels); err != nil {
+			return nil, err
+		}
+		for _, l := range labels {
+			ls.m[l.Address] = l.AddressMetadata
+		}
+	}
+	return ls, nil
+}
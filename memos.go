@@ -0,0 +1,351 @@
+package walrus
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+// DefaultMaxMemoSize is the default maximum size of a memo, in bytes.
+const DefaultMaxMemoSize = 64 << 10
+
+// A Memo is an arbitrary payload associated with a transaction. Memos are not
+// stored on the blockchain; they exist only in the local wallet.
+type Memo struct {
+	TransactionID types.TransactionID `json:"transactionID"`
+	ContentType   string              `json:"contentType"`
+	// Modified is the time the memo was last set. It is zero for memos set
+	// by older versions of walrus.
+	Modified time.Time `json:"modified"`
+	Data     []byte    `json:"-"`
+}
+
+// IsText reports whether the memo's data is UTF-8 text.
+func (m Memo) IsText() bool {
+	return isTextContentType(m.ContentType) && utf8.Valid(m.Data)
+}
+
+// MarshalJSON implements json.Marshaler. Text memos are encoded as a string
+// in the "text" field; all other memos are base64-encoded in the "data" field.
+func (m Memo) MarshalJSON() ([]byte, error) {
+	text, data := memoFields(m)
+	return json.Marshal(struct {
+		TransactionID types.TransactionID `json:"transactionID"`
+		ContentType   string              `json:"contentType"`
+		Modified      time.Time           `json:"modified"`
+		Size          int                 `json:"size"`
+		Text          string              `json:"text,omitempty"`
+		Data          []byte              `json:"data,omitempty"`
+	}{m.TransactionID, m.ContentType, m.Modified, len(m.Data), text, data})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Memo) UnmarshalJSON(b []byte) error {
+	var v struct {
+		TransactionID types.TransactionID `json:"transactionID"`
+		ContentType   string              `json:"contentType"`
+		Modified      time.Time           `json:"modified"`
+		Text          string              `json:"text"`
+		Data          []byte              `json:"data"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	m.TransactionID, m.ContentType, m.Modified = v.TransactionID, v.ContentType, v.Modified
+	m.Data = v.Data
+	if v.Text != "" {
+		m.Data = []byte(v.Text)
+	}
+	return nil
+}
+
+// memoFields returns the text or binary representation of m, as used in JSON
+// responses.
+func memoFields(m Memo) (text string, data []byte) {
+	if m.IsText() {
+		return string(m.Data), nil
+	}
+	return "", m.Data
+}
+
+// isTextContentType reports whether the media type ct is textual.
+func isTextContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") ||
+		mt == "application/json" || strings.HasSuffix(mt, "+json") ||
+		mt == "application/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+// MemoOptions configures a MemoStore.
+type MemoOptions struct {
+	// Path is the path of the file storing memo metadata. If empty, metadata
+	// is stored in the wallet alongside the memos themselves.
+	Path string
+	// MaxSize is the maximum size of a memo, in bytes. The default is
+	// DefaultMaxMemoSize.
+	MaxSize int
+}
+
+// memoMeta is the metadata of a memo. The memo data itself is stored in the
+// wallet.
+type memoMeta struct {
+	TransactionID types.TransactionID `json:"transactionID"`
+	ContentType   string              `json:"contentType"`
+	Modified      time.Time           `json:"modified"`
+}
+
+// memoIndexID is the key under which a MemoStore without a Path stores its
+// metadata in the wallet. It is not the ID of any valid transaction.
+var memoIndexID = types.TransactionID(crypto.HashObject("walrus memo index"))
+
+// A MemoStore stores transaction memos. Memo data is stored in the wallet,
+// while their metadata (content type and modification time) is stored
+// separately.
+type MemoStore struct {
+	w    *wallet.SeedWallet
+	opts MemoOptions
+
+	mu      sync.Mutex
+	meta    map[types.TransactionID]memoMeta
+	indexed bool // whether meta includes memos set by older versions
+	// texts caches the text memos and their lowercase text, for searching.
+	// It is nil until the first search.
+	texts map[types.TransactionID]textMemo
+}
+
+// A textMemo is a text memo along with its lowercase text.
+type textMemo struct {
+	Memo
+	lower string
+}
+
+// legacyMemo returns the memo for txid stored in w, sniffing its content type.
+func legacyMemo(w *wallet.SeedWallet, txid types.TransactionID) (Memo, bool) {
+	data := w.Memo(txid)
+	if len(data) == 0 {
+		return Memo{}, false
+	}
+	return Memo{
+		TransactionID: txid,
+		ContentType:   http.DetectContentType(data),
+		Data:          data,
+	}, true
+}
+
+// Memo returns the memo associated with txid.
+func (ms *MemoStore) Memo(txid types.TransactionID) (Memo, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.memoLocked(txid)
+}
+
+// memoLocked returns the memo associated with txid. ms.mu must be held.
+func (ms *MemoStore) memoLocked(txid types.TransactionID) (Memo, bool) {
+	if txid == memoIndexID {
+		return Memo{}, false
+	}
+	m, ok := legacyMemo(ms.w, txid)
+	if !ok {
+		return Memo{}, false
+	}
+	if meta, ok := ms.meta[txid]; ok {
+		m.ContentType, m.Modified = meta.ContentType, meta.Modified
+	}
+	return m, true
+}
+
+// SetMemo sets the memo associated with txid, overwriting any previous memo.
+// If contentType is empty, it is detected automatically. An empty memo
+// deletes the previous memo.
+func (ms *MemoStore) SetMemo(txid types.TransactionID, contentType string, data []byte) error {
+	if len(data) == 0 {
+		return ms.DeleteMemo(txid)
+	} else if txid == memoIndexID {
+		return errors.New("reserved transaction ID")
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.w.SetMemo(txid, data)
+	meta := memoMeta{
+		TransactionID: txid,
+		ContentType:   contentType,
+		Modified:      time.Now(),
+	}
+	ms.meta[txid] = meta
+	if ms.texts != nil {
+		ms.cacheText(Memo{
+			TransactionID: txid,
+			ContentType:   meta.ContentType,
+			Modified:      meta.Modified,
+			Data:          append([]byte(nil), data...),
+		})
+	}
+	return ms.save()
+}
+
+// DeleteMemo deletes the memo associated with txid, if any.
+func (ms *MemoStore) DeleteMemo(txid types.TransactionID) error {
+	if txid == memoIndexID {
+		return nil
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.w.SetMemo(txid, nil)
+	delete(ms.texts, txid)
+	if _, ok := ms.meta[txid]; !ok {
+		return nil
+	}
+	delete(ms.meta, txid)
+	return ms.save()
+}
+
+// Memos returns all memos, ordered from most- to least-recently modified.
+func (ms *MemoStore) Memos() []Memo {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.indexLocked()
+	memos := make([]Memo, 0, len(ms.meta))
+	for txid := range ms.meta {
+		if m, ok := ms.memoLocked(txid); ok {
+			memos = append(memos, m)
+		}
+	}
+	sortMemos(memos)
+	return memos
+}
+
+// sortMemos sorts memos from most- to least-recently modified.
+func sortMemos(memos []Memo) {
+	sort.Slice(memos, func(i, j int) bool {
+		if !memos[i].Modified.Equal(memos[j].Modified) {
+			return memos[i].Modified.After(memos[j].Modified)
+		}
+		return memos[i].TransactionID.String() < memos[j].TransactionID.String()
+	})
+}
+
+// cacheText adds m to ms.texts if it is a text memo, or removes any cached
+// memo for its transaction otherwise. ms.mu must be held.
+func (ms *MemoStore) cacheText(m Memo) {
+	if !m.IsText() {
+		delete(ms.texts, m.TransactionID)
+		return
+	}
+	ms.texts[m.TransactionID] = textMemo{m, strings.ToLower(string(m.Data))}
+}
+
+// Search returns the text memos that contain query, ignoring case, ordered
+// from most- to least-recently modified. If prefix is true, only memos that
+// begin with query are returned. Text memos are cached in memory after the
+// first search, so subsequent searches do not read the wallet.
+func (ms *MemoStore) Search(query string, prefix bool) []Memo {
+	query = strings.ToLower(query)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.texts == nil {
+		ms.indexLocked()
+		ms.texts = make(map[types.TransactionID]textMemo)
+		for txid := range ms.meta {
+			if m, ok := ms.memoLocked(txid); ok {
+				ms.cacheText(m)
+			}
+		}
+	}
+	var matches []Memo
+	for _, m := range ms.texts {
+		if (prefix && strings.HasPrefix(m.lower, query)) || (!prefix && strings.Contains(m.lower, query)) {
+			matches = append(matches, m.Memo)
+		}
+	}
+	sortMemos(matches)
+	return matches
+}
+
+// indexLocked adds the memos set by older versions of walrus for the wallet's
+// transactions to ms.meta, so that they appear in Memos. Since this requires
+// scanning the wallet's history, it is deferred until the full set of memos is
+// needed, and performed at most once. ms.mu must be held.
+func (ms *MemoStore) indexLocked() {
+	if ms.indexed {
+		return
+	}
+	ms.indexed = true
+	txids := ms.w.Transactions(-1)
+	for _, txn := range ms.w.LimboTransactions() {
+		txids = append(txids, txn.ID())
+	}
+	for _, txid := range txids {
+		if _, ok := ms.meta[txid]; ok {
+			continue
+		}
+		if m, ok := legacyMemo(ms.w, txid); ok {
+			ms.meta[txid] = memoMeta{TransactionID: txid, ContentType: m.ContentType}
+		}
+	}
+}
+
+// save persists the store's metadata. ms.mu must be held.
+func (ms *MemoStore) save() error {
+	// once saved, the metadata is assumed to include every memo, so legacy
+	// memos must be indexed first
+	ms.indexLocked()
+	meta := make([]memoMeta, 0, len(ms.meta))
+	for _, m := range ms.meta {
+		meta = append(meta, m)
+	}
+	sort.Slice(meta, func(i, j int) bool {
+		return meta[i].TransactionID.String() < meta[j].TransactionID.String()
+	})
+	if ms.opts.Path == "" {
+		js, _ := json.Marshal(meta)
+		ms.w.SetMemo(memoIndexID, js)
+		return nil
+	}
+	return saveJSON(ms.opts.Path, meta)
+}
+
+// NewMemoStore returns a MemoStore that stores memos in w. If no metadata has
+// been saved yet, any memos already stored in w for the wallet's transactions
+// are indexed when they are first listed, so that they appear in Memos.
+func NewMemoStore(w *wallet.SeedWallet, opts MemoOptions) (*MemoStore, error) {
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxMemoSize
+	}
+	ms := &MemoStore{
+		w:    w,
+		opts: opts,
+		meta: make(map[types.TransactionID]memoMeta),
+	}
+	var meta []memoMeta
+	if opts.Path != "" {
+		if err := loadJSON(opts.Path, &meta); err != nil {
+			return nil, err
+		}
+	} else if js := w.Memo(memoIndexID); len(js) > 0 {
+		// if the index is unreadable, rebuild it
+		if err := json.Unmarshal(js, &meta); err != nil {
+			meta = nil
+		}
+	}
+	ms.indexed = meta != nil
+	for _, m := range meta {
+		ms.meta[m.TransactionID] = m
+	}
+	return ms, nil
+}
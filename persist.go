@@ -0,0 +1,42 @@
+package walrus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// saveJSON atomically writes the JSON encoding of v to path.
+func saveJSON(path string, v interface{}) error {
+	js, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + "_tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(js); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadJSON decodes the JSON file at path into v. If the file does not exist,
+// v is left unmodified and no error is returned.
+func loadJSON(path string, v interface{}) error {
+	js, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
@@ -0,0 +1,170 @@
+package walrus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+// RebroadcastOptions configures a Rebroadcaster.
+type RebroadcastOptions struct {
+	// Interval is the time between rebroadcasts. The default is 10 minutes.
+	Interval time.Duration
+	// MaxAge is the duration after which a transaction in Limbo is considered
+	// stale. If zero, transactions never become stale.
+	MaxAge time.Duration
+	// Expire causes stale transactions to be removed from Limbo. Otherwise,
+	// stale transactions are flagged, but continue to be rebroadcast.
+	Expire bool
+	// Hub, if non-nil, is notified when stale transactions are removed from
+	// Limbo.
+	Hub *EventHub
+}
+
+// A RebroadcastStatus describes the rebroadcast history of a transaction in
+// Limbo.
+type RebroadcastStatus struct {
+	LastAttempt time.Time `json:"lastAttempt"`
+	Attempts    int       `json:"attempts"`
+	// Error is the error returned by the transaction pool on the last
+	// attempt, if any.
+	Error string `json:"error,omitempty"`
+	// Stale indicates that the transaction has been in Limbo for longer than
+	// RebroadcastOptions.MaxAge.
+	Stale bool `json:"stale"`
+}
+
+// A Rebroadcaster periodically resubmits the transactions in a wallet's Limbo,
+// along with their unconfirmed parents, to a transaction pool. This ensures
+// that transactions are not lost if the transaction pool drops them, e.g.
+// after a restart.
+type Rebroadcaster struct {
+	w      *wallet.SeedWallet
+	tp     TransactionPool
+	opts   RebroadcastOptions
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status map[types.TransactionID]RebroadcastStatus
+}
+
+// limboAncestors returns the transactions in limbo that txn depends on,
+// directly or indirectly, ordered such that each transaction appears after
+// its parents.
+func limboAncestors(txn types.Transaction, limbo []wallet.LimboTransaction) []types.Transaction {
+	var ancestors []types.Transaction
+	seen := make(map[types.TransactionID]struct{})
+	var visit func(txn types.Transaction)
+	visit = func(txn types.Transaction) {
+		for _, parent := range wallet.UnconfirmedParents(txn, limbo) {
+			txid := parent.ID()
+			if _, ok := seen[txid]; ok {
+				continue
+			}
+			seen[txid] = struct{}{}
+			visit(parent.Transaction)
+			ancestors = append(ancestors, parent.Transaction)
+		}
+	}
+	visit(txn)
+	return ancestors
+}
+
+// Status returns the rebroadcast status of the specified transaction, which
+// must be in Limbo.
+func (r *Rebroadcaster) Status(txid types.TransactionID) (RebroadcastStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.status[txid]
+	return s, ok
+}
+
+// rebroadcast resubmits each transaction in Limbo, and expires stale
+// transactions if requested.
+func (r *Rebroadcaster) rebroadcast() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	limbo := r.w.LimboTransactions()
+	conflicts := limboConflicts(r.w, limbo)
+	status := make(map[types.TransactionID]RebroadcastStatus, len(limbo))
+	var expired bool
+	for _, txn := range limbo {
+		txid := txn.ID()
+		s := r.status[txid]
+		s.Stale = r.opts.MaxAge > 0 && now.Sub(txn.LimboSince) > r.opts.MaxAge
+		if s.Stale && r.opts.Expire {
+			r.w.RemoveFromLimbo(txid)
+			expired = true
+			continue
+		}
+		if _, ok := conflicts[txid]; ok {
+			// the transaction can never be confirmed
+			status[txid] = s
+			continue
+		}
+		txnSet := append(limboAncestors(txn.Transaction, limbo), txn.Transaction)
+		err := r.tp.AcceptTransactionSet(txnSet)
+		s.LastAttempt = now
+		s.Attempts++
+		s.Error = ""
+		if err != nil && !errors.Is(err, modules.ErrDuplicateTransactionSet) {
+			s.Error = err.Error()
+		}
+		status[txid] = s
+	}
+	r.status = status
+	if expired && r.opts.Hub != nil {
+		r.opts.Hub.SyncLimbo()
+	}
+}
+
+func (r *Rebroadcaster) run(ctx context.Context) {
+	defer close(r.done)
+	// don't wait a full interval before the first rebroadcast; transactions
+	// may have been dropped while we were offline
+	r.rebroadcast()
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.rebroadcast()
+		}
+	}
+}
+
+// Close stops the Rebroadcaster.
+func (r *Rebroadcaster) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// NewRebroadcaster returns a Rebroadcaster that immediately resubmits the
+// transactions in w's Limbo to tp, and then continues to do so periodically.
+func NewRebroadcaster(w *wallet.SeedWallet, tp TransactionPool, opts RebroadcastOptions) *Rebroadcaster {
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Minute
+	}
+	r := &Rebroadcaster{
+		w:      w,
+		tp:     tp,
+		opts:   opts,
+		done:   make(chan struct{}),
+		status: make(map[types.TransactionID]RebroadcastStatus),
+	}
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run(ctx)
+	return r
+}
@@ -0,0 +1,191 @@
+package walrus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+type recordingTpool struct {
+	stubTpool
+	mu   sync.Mutex
+	sets [][]types.Transaction
+	err  error
+}
+
+func (tp *recordingTpool) AcceptTransactionSet(txnSet []types.Transaction) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.sets = append(tp.sets, txnSet)
+	return tp.err
+}
+
+func (tp *recordingTpool) numSets() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return len(tp.sets)
+}
+
+// waitFor polls fn until it returns true, failing the test after a second.
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for start := time.Now(); !fn(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+func TestRebroadcasterImmediate(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	tp := new(recordingTpool)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}}},
+	}
+	w.AddToLimbo(txn)
+
+	// the first rebroadcast should not wait for the interval to elapse
+	r := NewRebroadcaster(w, tp, RebroadcastOptions{Interval: time.Hour})
+	defer r.Close()
+	waitFor(t, func() bool { return tp.numSets() == 1 })
+	if s, ok := r.Status(txn.ID()); !ok || s.Attempts != 1 {
+		t.Fatal("wrong status:", s)
+	}
+}
+
+func TestRebroadcaster(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	tp := new(recordingTpool)
+
+	// add a chain of three transactions to Limbo
+	parent := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.SiacoinPrecision}},
+	}
+	child := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: parent.SiacoinOutputID(0)}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.SiacoinPrecision}},
+	}
+	grandchild := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: child.SiacoinOutputID(0)}},
+	}
+	for _, txn := range []types.Transaction{parent, child, grandchild} {
+		w.AddToLimbo(txn)
+	}
+
+	r := NewRebroadcaster(w, tp, RebroadcastOptions{Interval: time.Hour})
+	defer r.Close()
+	waitFor(t, func() bool { return tp.numSets() == 3 })
+
+	// each transaction should be broadcast along with its ancestors
+	exp := map[types.TransactionID][]types.TransactionID{
+		parent.ID():     {parent.ID()},
+		child.ID():      {parent.ID(), child.ID()},
+		grandchild.ID(): {parent.ID(), child.ID(), grandchild.ID()},
+	}
+	if len(tp.sets) != 3 {
+		t.Fatal("expected 3 transaction sets, got", len(tp.sets))
+	}
+	for _, set := range tp.sets {
+		ids := exp[set[len(set)-1].ID()]
+		if len(ids) != len(set) {
+			t.Fatal("wrong transaction set length:", len(set))
+		}
+		for i := range set {
+			if set[i].ID() != ids[i] {
+				t.Fatal("wrong transaction set order")
+			}
+		}
+	}
+	if s, ok := r.Status(child.ID()); !ok {
+		t.Fatal("missing status")
+	} else if s.Attempts != 1 || s.Stale || s.Error != "" {
+		t.Fatal("wrong status:", s)
+	}
+
+	// errors should be recorded
+	tp.err = errors.New("tpool is full")
+	r.rebroadcast()
+	if s, _ := r.Status(child.ID()); s.Attempts != 2 || s.Error != "tpool is full" {
+		t.Fatal("wrong status:", s)
+	}
+
+	// the status should appear in /limbo
+	client, stop := runServer(NewServer(w, stubTpool{}, Rebroadcasts(r)))
+	defer stop()
+	var limbo []struct {
+		ID          types.TransactionID `json:"id"`
+		Rebroadcast *RebroadcastStatus  `json:"rebroadcast"`
+	}
+	if err := client.get("/limbo", &limbo); err != nil {
+		t.Fatal(err)
+	} else if len(limbo) != 3 {
+		t.Fatal("expected 3 limbo transactions, got", len(limbo))
+	}
+	for _, txn := range limbo {
+		if txn.Rebroadcast == nil || txn.Rebroadcast.Attempts != 2 {
+			t.Fatal("missing or wrong rebroadcast status for", txn.ID)
+		}
+	}
+}
+
+func TestRebroadcasterMaxAge(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := hub.Subscribe(EventLimboRemoved)
+	defer unsubscribe()
+	tp := new(recordingTpool)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}}},
+	}
+	w.AddToLimbo(txn)
+	hub.SyncLimbo()
+	time.Sleep(time.Millisecond)
+
+	// without Expire, stale transactions are flagged and rebroadcast
+	r := NewRebroadcaster(w, tp, RebroadcastOptions{
+		Interval: time.Hour,
+		MaxAge:   time.Millisecond,
+		Hub:      hub,
+	})
+	defer r.Close()
+	waitFor(t, func() bool { return tp.numSets() == 1 })
+	if s, _ := r.Status(txn.ID()); !s.Stale || s.Attempts != 1 {
+		t.Fatal("wrong status:", s)
+	} else if len(w.LimboTransactions()) != 1 {
+		t.Fatal("transaction should remain in Limbo")
+	}
+
+	// with Expire, they are removed
+	r2 := NewRebroadcaster(w, tp, RebroadcastOptions{
+		Interval: time.Hour,
+		MaxAge:   time.Millisecond,
+		Expire:   true,
+		Hub:      hub,
+	})
+	defer r2.Close()
+	waitFor(t, func() bool { return len(w.LimboTransactions()) == 0 })
+	if _, ok := r2.Status(txn.ID()); ok {
+		t.Fatal("removed transaction should not have a status")
+	} else if len(tp.sets) != 1 {
+		t.Fatal("stale transaction should not be rebroadcast")
+	}
+	select {
+	case e := <-events:
+		if *e.TransactionID != txn.ID() {
+			t.Fatal("wrong transaction in event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no limboRemoved event")
+	}
+}
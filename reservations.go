@@ -0,0 +1,138 @@
+package walrus
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/frand"
+)
+
+// MaxReservationDuration is the maximum duration of a reservation.
+const MaxReservationDuration = 24 * time.Hour
+
+// A Reservation is a lease on an output. Reserved outputs are not selected
+// by funding requests until the reservation expires or is released.
+type Reservation struct {
+	ID     types.SiacoinOutputID `json:"id"`
+	Expiry time.Time             `json:"expiry"`
+	// Token is required to release the reservation. It is only reported to
+	// the client that created the reservation.
+	Token string `json:"token,omitempty"`
+}
+
+var (
+	// errOutputReserved is returned when attempting to reserve an output that
+	// is already reserved.
+	errOutputReserved = errors.New("output is already reserved")
+
+	// errWrongReservationToken is returned when attempting to release a
+	// reservation without its token.
+	errWrongReservationToken = errors.New("wrong reservation token")
+)
+
+// reservationDuration converts secs to a time.Duration, rejecting durations
+// that exceed MaxReservationDuration.
+func reservationDuration(secs uint64) (time.Duration, error) {
+	if secs > uint64(MaxReservationDuration/time.Second) {
+		return 0, fmt.Errorf("Reservation duration may not exceed %v seconds", uint64(MaxReservationDuration/time.Second))
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// A lease is a reservation on an output.
+type lease struct {
+	expiry time.Time
+	token  string
+}
+
+// A reservationSet tracks reserved outputs.
+type reservationSet struct {
+	mu sync.Mutex
+	m  map[types.SiacoinOutputID]lease
+}
+
+// pruneLocked removes expired reservations. rs.mu must be held.
+func (rs *reservationSet) pruneLocked() {
+	now := time.Now()
+	for id, l := range rs.m {
+		if !l.expiry.After(now) {
+			delete(rs.m, id)
+		}
+	}
+}
+
+// reservedLocked reports whether id is reserved. rs.mu must be held.
+func (rs *reservationSet) reservedLocked(id types.SiacoinOutputID) bool {
+	l, ok := rs.m[id]
+	return ok && l.expiry.After(time.Now())
+}
+
+// reserveLocked reserves the specified outputs until expiry, returning the
+// token required to release them. If any output is already reserved, no
+// outputs are reserved. rs.mu must be held.
+func (rs *reservationSet) reserveLocked(ids []types.SiacoinOutputID, expiry time.Time) (string, error) {
+	for _, id := range ids {
+		if rs.reservedLocked(id) {
+			return "", errOutputReserved
+		}
+	}
+	rs.pruneLocked()
+	token := hex.EncodeToString(frand.Bytes(16))
+	for _, id := range ids {
+		rs.m[id] = lease{expiry, token}
+	}
+	return token, nil
+}
+
+func (rs *reservationSet) reserve(ids []types.SiacoinOutputID, expiry time.Time) (string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.reserveLocked(ids, expiry)
+}
+
+// release releases the reservations on the specified outputs, which must
+// have been created with token. If any active reservation has a different
+// token, no reservations are released.
+func (rs *reservationSet) release(token string, ids ...types.SiacoinOutputID) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, id := range ids {
+		if rs.reservedLocked(id) && rs.m[id].token != token {
+			return errWrongReservationToken
+		}
+	}
+	for _, id := range ids {
+		delete(rs.m, id)
+	}
+	return nil
+}
+
+func (rs *reservationSet) reserved(id types.SiacoinOutputID) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.reservedLocked(id)
+}
+
+// list returns all active reservations, ordered by expiry.
+func (rs *reservationSet) list() []Reservation {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.pruneLocked()
+	res := make([]Reservation, 0, len(rs.m))
+	for id, l := range rs.m {
+		res = append(res, Reservation{ID: id, Expiry: l.expiry})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Expiry.Before(res[j].Expiry)
+	})
+	return res
+}
+
+func newReservationSet() *reservationSet {
+	return &reservationSet{m: make(map[types.SiacoinOutputID]lease)}
+}
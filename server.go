@@ -1,12 +1,20 @@
 package walrus
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"go.sia.tech/siad/crypto"
@@ -37,6 +45,92 @@ func writeJSON(w http.ResponseWriter, v interface{}) {
 	enc.Encode(v)
 }
 
+// NextCursorHeader is the HTTP header containing the cursor for the next page
+// of a paginated response. It is omitted from the final page.
+const NextCursorHeader = "Walrus-Next-Cursor"
+
+// ConsensusHeightHeader and ConsensusIDHeader are the HTTP headers containing
+// the height and consensus change ID of the consensus state that a balance
+// was computed at.
+const (
+	ConsensusHeightHeader = "Walrus-Consensus-Height"
+	ConsensusIDHeader     = "Walrus-Consensus-ID"
+)
+
+// maxBalanceAttempts is the number of times /balance attempts to compute a
+// balance before giving up, if the consensus state keeps changing.
+const maxBalanceAttempts = 3
+
+// defaultPageLimit is the number of items returned when a paginated request
+// specifies a limit of 0.
+const defaultPageLimit = 100
+
+// parsePage parses the limit and cursor query parameters of a paginated
+// request. If no limit is specified, limit is -1; if the limit is 0, the
+// default limit is used.
+func parsePage(req *http.Request) (limit int, cursor string, err error) {
+	limit = -1
+	if req.FormValue("limit") != "" {
+		limit, err = strconv.Atoi(req.FormValue("limit"))
+		if err != nil {
+			return 0, "", errors.New("Invalid 'limit' value: " + err.Error())
+		} else if limit < 0 {
+			return 0, "", errors.New("Invalid 'limit' value: must be non-negative")
+		} else if limit == 0 {
+			limit = defaultPageLimit
+		}
+	}
+	return limit, req.FormValue("cursor"), nil
+}
+
+// encodeCursor returns the cursor identifying the item at index i, whose key
+// is key.
+func encodeCursor(i int, key string) string {
+	return strconv.Itoa(i) + ":" + key
+}
+
+// resolveCursor returns the index of the item following the one identified by
+// cursor, or 0 if cursor is empty. The item is expected at the index recorded
+// in the cursor; if the list has shifted since the cursor was issued (e.g.
+// because items were added), the item is located by its key instead.
+func resolveCursor(n int, cursor string, keyAt func(int) string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	if i := strings.IndexByte(cursor, ':'); i >= 0 {
+		pos, err := strconv.Atoi(cursor[:i])
+		key := cursor[i+1:]
+		if err == nil && 0 <= pos && pos < n && keyAt(pos) == key {
+			return pos + 1, nil
+		}
+		cursor = key
+	}
+	for i := 0; i < n; i++ {
+		if keyAt(i) == cursor {
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("Invalid cursor")
+}
+
+// paginate returns the bounds of the page of up to limit items following the
+// item identified by cursor, along with the cursor for the next page. If
+// cursor is empty, the page begins with the first item.
+func paginate(n int, limit int, cursor string, keyAt func(int) string) (start, end int, next string, err error) {
+	start, err = resolveCursor(n, cursor, keyAt)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	end = n
+	if limit >= 0 && start+limit < n {
+		end = start + limit
+	}
+	if end < n && end > start {
+		next = encodeCursor(end-1, keyAt(end-1))
+	}
+	return start, end, next, nil
+}
+
 func calculateFlows(txn wallet.Transaction, owner wallet.AddressOwner) (credit, debit types.Currency) {
 	for i, sci := range txn.SiacoinInputs {
 		if owner.OwnsAddress(wallet.CalculateUnlockHash(sci.UnlockConditions)) {
@@ -51,51 +145,295 @@ func calculateFlows(txn wallet.Transaction, owner wallet.AddressOwner) (credit,
 	return
 }
 
+// responseTransaction returns the API representation of txn. If memos is nil,
+// the memo is read directly from w.
+func responseTransaction(txn wallet.Transaction, w *wallet.SeedWallet, memos *MemoStore) ResponseTransactionsID {
+	credit, debit := calculateFlows(txn, w)
+	var fee types.Currency
+	for _, f := range txn.MinerFees {
+		fee = fee.Add(f)
+	}
+	// confirmations are counted inclusively, i.e. a transaction in the most
+	// recent block has one confirmation
+	confirmations := uint64(1)
+	if height := w.ChainHeight(); height > txn.BlockHeight {
+		confirmations += uint64(height - txn.BlockHeight)
+	}
+	var memo Memo
+	if memos != nil {
+		memo, _ = memos.Memo(txn.ID())
+	} else {
+		memo, _ = legacyMemo(w, txn.ID())
+	}
+	text, data := memoFields(memo)
+	return ResponseTransactionsID{
+		Transaction:   txn.Transaction,
+		BlockID:       txn.BlockID,
+		BlockHeight:   txn.BlockHeight,
+		Timestamp:     txn.Timestamp,
+		FeePerByte:    txn.FeePerByte,
+		Credit:        credit,
+		Debit:         debit,
+		Fee:           fee,
+		Confirmations: confirmations,
+		Status:        StatusConfirmed,
+		Memo:          text,
+		MemoData:      data,
+		MemoType:      memo.ContentType,
+	}
+}
+
+// transaction returns the transaction with the specified ID, which may be
+// confirmed, in Limbo, or reverted.
+func (s *server) transaction(txid types.TransactionID) (ResponseTransactionsID, bool) {
+	if txn, ok := s.w.Transaction(txid); ok {
+		return responseTransaction(txn, s.w, s.memos), true
+	}
+	limbo := s.w.LimboTransactions()
+	for _, ltxn := range limbo {
+		if ltxn.ID() == txid {
+			return s.limboTransaction(ltxn, limboInputValues(s.w, limbo)), true
+		}
+	}
+	if s.events != nil {
+		if txn, ok := s.events.revertedTransaction(txid); ok {
+			return s.revertedTransaction(txn), true
+		}
+	}
+	return ResponseTransactionsID{}, false
+}
+
+// limboInputValues returns the values of the outputs that transactions in
+// limbo may spend: the wallet's unspent outputs, and the outputs of other
+// transactions in limbo.
+func limboInputValues(w *wallet.SeedWallet, limbo []wallet.LimboTransaction) map[types.SiacoinOutputID]types.Currency {
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, o := range w.UnspentOutputs(false) {
+		values[o.ID] = o.Value
+	}
+	for _, parent := range limbo {
+		for i, sco := range parent.SiacoinOutputs {
+			values[parent.SiacoinOutputID(uint64(i))] = sco.Value
+		}
+	}
+	return values
+}
+
+// limboTransaction returns the API representation of ltxn, using values (see
+// limboInputValues) to determine its input values.
+func (s *server) limboTransaction(ltxn wallet.LimboTransaction, values map[types.SiacoinOutputID]types.Currency) ResponseTransactionsID {
+	txn := wallet.Transaction{
+		Transaction: ltxn.Transaction,
+		Timestamp:   ltxn.LimboSince,
+		InputValues: make([]types.Currency, len(ltxn.SiacoinInputs)),
+	}
+	for i, sci := range ltxn.SiacoinInputs {
+		txn.InputValues[i] = values[sci.ParentID]
+	}
+	var fee types.Currency
+	for _, f := range txn.MinerFees {
+		fee = fee.Add(f)
+	}
+	txn.FeePerByte = fee.Div64(uint64(txn.MarshalSiaSize()))
+	resp := responseTransaction(txn, s.w, s.memos)
+	resp.Confirmations = 0
+	resp.Status = StatusLimbo
+	return resp
+}
+
+// revertedTransaction returns the API representation of txn, which appeared
+// in a reverted block.
+func (s *server) revertedTransaction(txn wallet.Transaction) ResponseTransactionsID {
+	resp := responseTransaction(txn, s.w, s.memos)
+	resp.Confirmations = 0
+	resp.Status = StatusReverted
+	return resp
+}
+
 type server struct {
-	w  *wallet.SeedWallet
-	tp TransactionPool
+	w      *wallet.SeedWallet
+	tp     TransactionPool
+	creds  []credential
+	cors   *CORSOptions
+	events *EventHub
+	res    *reservationSet
+	memos  *MemoStore
+	labels *LabelStore
+
+	rebroadcaster *Rebroadcaster
+	heights       outputHeightCache
+}
+
+// A ServerOption configures a server returned by NewServer.
+type ServerOption func(*server)
+
+// Events enables the /events endpoint, which streams events from the supplied
+// EventHub. The EventHub also supplies the reverted status of transactions,
+// which is not reported otherwise.
+func Events(h *EventHub) ServerOption {
+	return func(s *server) {
+		s.events = h
+	}
+}
+
+// Memos configures the server to store transaction memos in ms. If this
+// option is not supplied, memo metadata is stored in the wallet.
+func Memos(ms *MemoStore) ServerOption {
+	return func(s *server) {
+		s.memos = ms
+	}
+}
+
+// Labels configures the server to store address metadata in ls. If this
+// option is not supplied, address metadata is not persisted.
+func Labels(ls *LabelStore) ServerOption {
+	return func(s *server) {
+		s.labels = ls
+	}
+}
+
+// Rebroadcasts includes the status of the supplied Rebroadcaster in the
+// response of the /limbo endpoint.
+func Rebroadcasts(r *Rebroadcaster) ServerOption {
+	return func(s *server) {
+		s.rebroadcaster = r
+	}
+}
+
+// syncLimbo notifies the server's EventHub (if any) that Limbo may have
+// changed.
+func (s *server) syncLimbo() {
+	if s.events != nil {
+		s.events.SyncLimbo()
+	}
 }
 
 func (s *server) addressesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	writeJSON(w, s.w.Addresses())
+	req.ParseForm()
+	tags := req.Form["tag"]
+	addrs := s.w.Addresses()
+	if len(tags) > 0 {
+		filtered := make([]types.UnlockHash, 0, len(addrs))
+		for _, addr := range addrs {
+			if s.labels.Metadata(addr).HasTags(tags...) {
+				filtered = append(filtered, addr)
+			}
+		}
+		addrs = filtered
+	}
+	if req.FormValue("full") == "true" {
+		resp := make([]ResponseAddress, 0, len(addrs))
+		for _, addr := range addrs {
+			if info, ok := s.w.AddressInfo(addr); ok {
+				resp = append(resp, s.responseAddress(addr, info))
+			}
+		}
+		writeJSON(w, resp)
+		return
+	}
+	writeJSON(w, addrs)
+}
+
+func (s *server) responseAddress(addr types.UnlockHash, info wallet.SeedAddressInfo) ResponseAddress {
+	return ResponseAddress{
+		Address:         addr,
+		SeedAddressInfo: info,
+		AddressMetadata: s.labels.Metadata(addr),
+	}
 }
 
 func (s *server) addressesaddrHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var addr types.UnlockHash
 	if err := addr.LoadString(ps.ByName("addr")); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidAddress, err.Error())
 		return
 	}
 	info, ok := s.w.AddressInfo(addr)
 	if !ok {
-		http.Error(w, "No such entry", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "No such entry")
 		return
 	}
-	writeJSON(w, responseAddressesAddr(info))
+	writeJSON(w, s.responseAddress(addr, info))
 }
 
 func (s *server) addressesHandlerPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
-	var info wallet.SeedAddressInfo
-	if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var ra RequestAddresses
+	if err := json.NewDecoder(req.Body).Decode(&ra); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	md, err := ra.AddressMetadata.normalize()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid metadata: "+err.Error())
+		return
+	}
+	addr := wallet.CalculateUnlockHash(ra.UnlockConditions)
+	s.w.AddAddress(ra.SeedAddressInfo)
+	if !md.IsEmpty() {
+		if err := s.labels.SetMetadata(addr, md); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Couldn't save metadata: "+err.Error())
+			return
+		}
+	}
+	writeJSON(w, addr)
+}
+
+func (s *server) addressesaddrHandlerPUT(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var addr types.UnlockHash
+	if err := addr.LoadString(ps.ByName("addr")); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidAddress, err.Error())
+		return
+	}
+	var md AddressMetadata
+	if err := json.NewDecoder(req.Body).Decode(&md); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse metadata: "+err.Error())
+		return
+	}
+	md, err := md.normalize()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid metadata: "+err.Error())
+		return
+	}
+	if !s.w.OwnsAddress(addr) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "No such entry")
+		return
+	}
+	if err := s.labels.SetMetadata(addr, md); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Couldn't save metadata: "+err.Error())
 		return
 	}
-	s.w.AddAddress(info)
-	writeJSON(w, wallet.CalculateUnlockHash(info.UnlockConditions))
 }
 
 func (s *server) addressesaddrHandlerDELETE(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var addr types.UnlockHash
 	if err := addr.LoadString(ps.ByName("addr")); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidAddress, err.Error())
 		return
 	}
 	s.w.RemoveAddress(addr)
+	if err := s.labels.DeleteMetadata(addr); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Couldn't delete metadata: "+err.Error())
+		return
+	}
 }
 
 func (s *server) balanceHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	limbo := req.FormValue("limbo") == "true"
-	writeJSON(w, s.w.Balance(limbo))
+	excludeReserved := req.FormValue("excludeReserved") == "true"
+	// if the consensus state changes while the balance is computed, try again,
+	// so that the reported state is the one the balance reflects
+	for attempt := 0; attempt < maxBalanceAttempts; attempt++ {
+		ccid := s.w.ConsensusChangeID()
+		height := s.w.ChainHeight()
+		bal := wallet.SumOutputs(s.unspentOutputs(limbo, excludeReserved))
+		if s.w.ConsensusChangeID() == ccid {
+			w.Header().Set(ConsensusHeightHeader, strconv.FormatUint(uint64(height), 10))
+			w.Header().Set(ConsensusIDHeader, crypto.Hash(ccid).String())
+			writeJSON(w, bal)
+			return
+		}
+	}
+	writeError(w, http.StatusServiceUnavailable, CodeInternal, "Consensus state changed while computing balance; try again later")
 }
 
 func (s *server) batchqueryHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -103,7 +441,7 @@ func (s *server) batchqueryHandler(w http.ResponseWriter, req *http.Request, ps
 	case "addresses":
 		var addrs []types.UnlockHash
 		if err := json.NewDecoder(req.Body).Decode(&addrs); err != nil {
-			http.Error(w, "Could not parse addrs: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse addrs: "+err.Error())
 			return
 		}
 		infos := make(responseBatchqueryAddresses, len(addrs))
@@ -116,27 +454,18 @@ func (s *server) batchqueryHandler(w http.ResponseWriter, req *http.Request, ps
 	case "transactions":
 		var ids []types.TransactionID
 		if err := json.NewDecoder(req.Body).Decode(&ids); err != nil {
-			http.Error(w, "Could not parse ids: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse ids: "+err.Error())
 			return
 		}
 		txns := make(responseBatchqueryTransactions, len(ids))
 		for _, id := range ids {
-			if txn, ok := s.w.Transaction(id); ok {
-				credit, debit := calculateFlows(txn, s.w)
-				txns[id] = ResponseTransactionsID{
-					Transaction: txn.Transaction,
-					BlockID:     txn.BlockID,
-					BlockHeight: txn.BlockHeight,
-					Timestamp:   txn.Timestamp,
-					FeePerByte:  txn.FeePerByte,
-					Credit:      credit,
-					Debit:       debit,
-				}
+			if txn, ok := s.transaction(id); ok {
+				txns[id] = txn
 			}
 		}
 		writeJSON(w, txns)
 	default:
-		http.Error(w, "batchquery endpoint must be one of: addresses, transactions", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "batchquery endpoint must be one of: addresses, transactions")
 	}
 }
 
@@ -146,20 +475,36 @@ func (s *server) blockrewardsHandler(w http.ResponseWriter, req *http.Request, _
 		var err error
 		max, err = strconv.Atoi(req.FormValue("max"))
 		if err != nil {
-			http.Error(w, "Invalid 'max' value: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'max' value: "+err.Error())
 			return
 		}
 	}
-	writeJSON(w, responseBlockRewards(s.w.BlockRewards(max)))
+	limit, cursor, err := parsePage(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	rewards := s.w.BlockRewards(max)
+	start, end, next, err := paginate(len(rewards), limit, cursor, func(i int) string {
+		return rewards[i].ID.String()
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	writeJSON(w, responseBlockRewards(rewards[start:end]))
 }
 
 func (s *server) broadcastHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	var txnSet []types.Transaction
 	if err := json.NewDecoder(req.Body).Decode(&txnSet); err != nil {
-		http.Error(w, "Could not parse transaction: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse transaction: "+err.Error())
 		return
 	} else if len(txnSet) == 0 {
-		http.Error(w, "Transaction set is empty", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Transaction set is empty")
 		return
 	}
 	// if transaction set in already on-chain, no-op
@@ -176,7 +521,11 @@ func (s *server) broadcastHandler(w http.ResponseWriter, req *http.Request, _ ht
 	// already in the tpool, great)
 	err := s.tp.AcceptTransactionSet(txnSet)
 	if err != nil && !errors.Is(err, modules.ErrDuplicateTransactionSet) {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		code := CodeTpoolRejected
+		if isLowFeeError(err) {
+			code = CodeInsufficientFee
+		}
+		writeError(w, http.StatusBadRequest, code, err.Error())
 		return
 	}
 
@@ -192,6 +541,7 @@ func (s *server) broadcastHandler(w http.ResponseWriter, req *http.Request, _ ht
 			s.w.AddToLimbo(txn)
 		}
 	}
+	s.syncLimbo()
 }
 
 func (s *server) consensusHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
@@ -201,9 +551,85 @@ func (s *server) consensusHandler(w http.ResponseWriter, req *http.Request, _ ht
 	})
 }
 
+func (s *server) eventsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if s.events == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Event streaming is not enabled")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+		return
+	}
+	var eventTypes []EventType
+	if req.FormValue("types") != "" {
+		for _, t := range strings.Split(req.FormValue("types"), ",") {
+			if !validEventType(EventType(t)) {
+				writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid event type: "+t)
+				return
+			}
+			eventTypes = append(eventTypes, EventType(t))
+		}
+	}
+
+	events, cancel := s.events.Subscribe(eventTypes...)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// send periodic comments to keep idle connections alive
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			js, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, js)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// fees returns the fee rate of each tier.
+func (s *server) fees() ResponseFee {
+	min, max := s.tp.FeeEstimation()
+	if max.Cmp(min) < 0 {
+		max = min
+	}
+	return ResponseFee{
+		Min:     min,
+		Max:     max,
+		Economy: min,
+		Normal:  min.Add(max).Div64(2),
+		Urgent:  max,
+	}
+}
+
 func (s *server) feeHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	median, _ := s.tp.FeeEstimation()
-	writeJSON(w, median)
+	fees := s.fees()
+	if req.FormValue("full") == "true" {
+		writeJSON(w, fees)
+		return
+	}
+	tier := FeeTier(req.FormValue("tier"))
+	if tier == "" {
+		tier = FeeEconomy
+	}
+	fee, ok := fees.Tier(tier)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid tier")
+		return
+	}
+	writeJSON(w, fee)
 }
 
 func (s *server) filecontractsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
@@ -212,78 +638,384 @@ func (s *server) filecontractsHandler(w http.ResponseWriter, req *http.Request,
 		var err error
 		max, err = strconv.Atoi(req.FormValue("max"))
 		if err != nil {
-			http.Error(w, "Invalid 'max' value: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'max' value: "+err.Error())
 			return
 		}
 	}
-	writeJSON(w, responseFileContracts(s.w.FileContracts(max)))
+	limit, cursor, err := parsePage(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	fcs := s.w.FileContracts(max)
+	start, end, next, err := paginate(len(fcs), limit, cursor, func(i int) string {
+		// a contract may appear multiple times (once per revision)
+		return fcs[i].ID.String() + "-" + strconv.FormatUint(fcs[i].RevisionNumber, 10)
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	writeJSON(w, responseFileContracts(fcs[start:end]))
 }
 
 func (s *server) filecontractsidHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var id types.FileContractID
 	if err := id.LoadString(ps.ByName("id")); err != nil {
-		http.Error(w, "Invalid ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID: "+err.Error())
 		return
 	}
 	writeJSON(w, responseFileContracts(s.w.FileContractHistory(id)))
 }
 
 func (s *server) limboHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	writeJSON(w, responseLimbo(s.w.LimboTransactions()))
+	txns := s.w.LimboTransactions()
+	conflicts := s.limboConflicts(txns)
+	resp := make(responseLimbo, len(txns))
+	for i, txn := range txns {
+		resp[i].LimboTransaction = txn
+		if conflict, ok := conflicts[txn.ID()]; ok {
+			resp[i].ConflictingID = &conflict
+		}
+		if s.rebroadcaster != nil {
+			if status, ok := s.rebroadcaster.Status(txn.ID()); ok {
+				resp[i].Rebroadcast = &status
+			}
+		}
+	}
+	writeJSON(w, resp)
 }
 
 func (s *server) limboHandlerPUT(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var txn types.Transaction
 	if err := json.NewDecoder(req.Body).Decode(&txn); err != nil {
-		http.Error(w, "Could not parse transaction: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse transaction: "+err.Error())
 		return
 	}
 	s.w.AddToLimbo(txn)
+	s.syncLimbo()
 }
 
 func (s *server) limboHandlerDELETE(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var txid types.TransactionID
 	if err := (*crypto.Hash)(&txid).LoadString(ps.ByName("id")); err != nil {
-		http.Error(w, "Invalid ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid ID: "+err.Error())
 		return
 	}
 	s.w.RemoveFromLimbo(txid)
+	s.syncLimbo()
+}
+
+func (s *server) memosHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	limit, cursor, err := parsePage(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	memos := s.memos.Memos()
+	start, end, next, err := paginate(len(memos), limit, cursor, func(i int) string {
+		return memos[i].TransactionID.String()
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	writeJSON(w, memos[start:end])
+}
+
+func (s *server) memosearchHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	query := req.FormValue("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "No query specified")
+		return
+	}
+	var prefix bool
+	switch req.FormValue("match") {
+	case "", "substring":
+	case "prefix":
+		prefix = true
+	default:
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'match' value: must be substring or prefix")
+		return
+	}
+	limit, cursor, err := parsePage(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	// determine the timestamp of each match; full responses are only built
+	// for the requested page
+	type match struct {
+		txid     types.TransactionID
+		txn      wallet.Transaction
+		limbo    *wallet.LimboTransaction
+		reverted bool
+	}
+	limbo := s.w.LimboTransactions()
+	limboByID := make(map[types.TransactionID]*wallet.LimboTransaction, len(limbo))
+	for i := range limbo {
+		limboByID[limbo[i].ID()] = &limbo[i]
+	}
+	var matches []match
+	for _, m := range s.memos.Search(query, prefix) {
+		// memos may refer to transactions unknown to the wallet
+		if txn, ok := s.w.Transaction(m.TransactionID); ok {
+			matches = append(matches, match{txid: m.TransactionID, txn: txn})
+		} else if ltxn, ok := limboByID[m.TransactionID]; ok {
+			matches = append(matches, match{txid: m.TransactionID, txn: wallet.Transaction{Timestamp: ltxn.LimboSince}, limbo: ltxn})
+		} else if s.events == nil {
+			continue
+		} else if txn, ok := s.events.revertedTransaction(m.TransactionID); ok {
+			matches = append(matches, match{txid: m.TransactionID, txn: txn, reverted: true})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].txn.Timestamp.After(matches[j].txn.Timestamp)
+	})
+	start, end, next, err := paginate(len(matches), limit, cursor, func(i int) string {
+		return matches[i].txid.String()
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+	var values map[types.SiacoinOutputID]types.Currency
+	txns := make([]ResponseTransactionsID, 0, end-start)
+	for _, m := range matches[start:end] {
+		switch {
+		case m.limbo != nil:
+			if values == nil {
+				values = limboInputValues(s.w, limbo)
+			}
+			txns = append(txns, s.limboTransaction(*m.limbo, values))
+		case m.reverted:
+			txns = append(txns, s.revertedTransaction(m.txn))
+		default:
+			txns = append(txns, responseTransaction(m.txn, s.w, s.memos))
+		}
+	}
+	writeJSON(w, txns)
 }
 
 func (s *server) memosHandlerPUT(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var txid types.TransactionID
 	if err := (*crypto.Hash)(&txid).LoadString(ps.ByName("txid")); err != nil {
-		http.Error(w, "Invalid transaction ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid transaction ID: "+err.Error())
 		return
 	}
-	body, err := ioutil.ReadAll(req.Body)
+	contentType := req.Header.Get("Content-Type")
+	if contentType != "" {
+		mt, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid Content-Type: "+err.Error())
+			return
+		}
+		contentType = mime.FormatMediaType(mt, params)
+		if mt == "application/x-www-form-urlencoded" {
+			// curl's default for -d; almost certainly unintended
+			contentType = ""
+		}
+	}
+	maxSize := s.memos.opts.MaxSize
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, int64(maxSize)+1))
 	if err != nil {
-		http.Error(w, "Couldn't read memo: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Couldn't read memo: "+err.Error())
+		return
+	} else if len(body) > maxSize {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeMemoTooLarge, fmt.Sprintf("Memo exceeds maximum size of %v bytes", maxSize))
+		return
+	}
+	if err := s.memos.SetMemo(txid, contentType, body); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Couldn't save memo: "+err.Error())
 		return
 	}
-	s.w.SetMemo(txid, body)
 }
 
 func (s *server) memosHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var txid types.TransactionID
 	if err := (*crypto.Hash)(&txid).LoadString(ps.ByName("txid")); err != nil {
-		http.Error(w, "Invalid transaction ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid transaction ID: "+err.Error())
+		return
+	}
+	memo, ok := s.memos.Memo(txid)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "No memo for transaction")
 		return
 	}
-	w.Write(s.w.Memo(txid))
+	w.Header().Set("Content-Type", memo.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(memo.Data)
+}
+
+func (s *server) memosHandlerDELETE(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var txid types.TransactionID
+	if err := (*crypto.Hash)(&txid).LoadString(ps.ByName("txid")); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid transaction ID: "+err.Error())
+		return
+	}
+	if err := s.memos.DeleteMemo(txid); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Couldn't delete memo: "+err.Error())
+		return
+	}
+}
+
+func (s *server) reservationsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	writeJSON(w, s.res.list())
+}
+
+func (s *server) reservationsHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rr RequestReservations
+	if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse request: "+err.Error())
+		return
+	} else if rr.Duration == 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Duration must be non-zero")
+		return
+	}
+	d, err := reservationDuration(rr.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	known := make(map[types.SiacoinOutputID]struct{})
+	for _, o := range append(s.w.UnspentOutputs(false), s.unspentOutputs(true, false)...) {
+		known[o.ID] = struct{}{}
+	}
+	for _, id := range rr.IDs {
+		if _, ok := known[id]; !ok {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Unknown output "+id.String())
+			return
+		}
+	}
+	expiry := time.Now().Add(d)
+	token, err := s.res.reserve(rr.IDs, expiry)
+	if err == errOutputReserved {
+		writeError(w, http.StatusConflict, CodeOutputReserved, "One or more outputs are already reserved")
+		return
+	}
+	resp := make([]Reservation, len(rr.IDs))
+	for i, id := range rr.IDs {
+		resp[i] = Reservation{ID: id, Expiry: expiry, Token: token}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *server) reservationsidHandlerDELETE(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var id crypto.Hash
+	if err := id.LoadString(ps.ByName("id")); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid output ID: "+err.Error())
+		return
+	}
+	if err := s.res.release(req.FormValue("token"), types.SiacoinOutputID(id)); err == errWrongReservationToken {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Reservation token does not match")
+	}
 }
 
 func (s *server) seedindexHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	writeJSON(w, s.w.SeedIndex())
 }
 
+// A transactionFilter restricts the transactions returned by /transactions.
+type transactionFilter struct {
+	minHeight, maxHeight *types.BlockHeight
+	since, until         time.Time
+	direction            string
+	minAmount            types.Currency
+}
+
+func (f transactionFilter) active() bool {
+	return f.minHeight != nil || f.maxHeight != nil || !f.since.IsZero() || !f.until.IsZero() ||
+		f.direction != "" || !f.minAmount.IsZero()
+}
+
+func (f transactionFilter) matches(txn wallet.Transaction, owner wallet.AddressOwner) bool {
+	if (f.minHeight != nil && txn.BlockHeight < *f.minHeight) || (f.maxHeight != nil && txn.BlockHeight > *f.maxHeight) {
+		return false
+	} else if (!f.since.IsZero() && txn.Timestamp.Before(f.since)) || (!f.until.IsZero() && txn.Timestamp.After(f.until)) {
+		return false
+	}
+	credit, debit := calculateFlows(txn, owner)
+	switch f.direction {
+	case "incoming":
+		if credit.Cmp(debit) <= 0 {
+			return false
+		}
+	case "outgoing":
+		if debit.Cmp(credit) <= 0 {
+			return false
+		}
+	}
+	var net types.Currency
+	if credit.Cmp(debit) > 0 {
+		net = credit.Sub(debit)
+	} else {
+		net = debit.Sub(credit)
+	}
+	return net.Cmp(f.minAmount) >= 0
+}
+
+func parseTime(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseTransactionFilter(req *http.Request) (f transactionFilter, err error) {
+	parseHeight := func(name string) (*types.BlockHeight, error) {
+		if req.FormValue(name) == "" {
+			return nil, nil
+		}
+		h, err := strconv.ParseUint(req.FormValue(name), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid '%v' value: %v", name, err)
+		}
+		return (*types.BlockHeight)(&h), nil
+	}
+	if f.minHeight, err = parseHeight("minHeight"); err != nil {
+		return
+	} else if f.maxHeight, err = parseHeight("maxHeight"); err != nil {
+		return
+	}
+	if v := req.FormValue("since"); v != "" {
+		if f.since, err = parseTime(v); err != nil {
+			return f, errors.New("Invalid 'since' value: " + err.Error())
+		}
+	}
+	if v := req.FormValue("until"); v != "" {
+		if f.until, err = parseTime(v); err != nil {
+			return f, errors.New("Invalid 'until' value: " + err.Error())
+		}
+	}
+	switch f.direction = req.FormValue("direction"); f.direction {
+	case "", "incoming", "outgoing":
+	default:
+		return f, errors.New("Invalid 'direction' value: must be 'incoming' or 'outgoing'")
+	}
+	if v := req.FormValue("minAmount"); v != "" {
+		if _, err := fmt.Sscan(v, &f.minAmount); err != nil {
+			return f, errors.New("Invalid 'minAmount' value: " + err.Error())
+		}
+	}
+	return f, nil
+}
+
 func (s *server) transactionsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	max := -1 // all txns
 	if req.FormValue("max") != "" {
 		var err error
 		max, err = strconv.Atoi(req.FormValue("max"))
 		if err != nil {
-			http.Error(w, "Invalid 'max' value: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Invalid 'max' value: "+err.Error())
 			return
 		}
 	}
@@ -292,80 +1024,530 @@ func (s *server) transactionsHandler(w http.ResponseWriter, req *http.Request, _
 	if req.FormValue("addr") != "" {
 		var addr types.UnlockHash
 		if err := addr.LoadString(req.FormValue("addr")); err != nil {
-			http.Error(w, "Invalid address: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, CodeInvalidAddress, "Invalid address: "+err.Error())
 			return
 		}
 		resp = s.w.TransactionsByAddress(addr, max)
 	} else {
 		resp = s.w.Transactions(max)
 	}
-	writeJSON(w, resp)
+	filter, err := parseTransactionFilter(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	limit, cursor, err := parsePage(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	// cursors refer to positions in the unfiltered list, so that each page
+	// only needs to examine the transactions that follow the previous page
+	start, err := resolveCursor(len(resp), cursor, func(i int) string {
+		return resp[i].String()
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	var page []types.TransactionID
+	last := -1
+	for i := start; i < len(resp); i++ {
+		if filter.active() {
+			if txn, ok := s.w.Transaction(resp[i]); !ok || !filter.matches(txn, s.w) {
+				continue
+			}
+		}
+		if limit >= 0 && len(page) == limit {
+			w.Header().Set(NextCursorHeader, encodeCursor(last, resp[last].String()))
+			break
+		}
+		page = append(page, resp[i])
+		last = i
+	}
+	if req.FormValue("full") == "true" {
+		txns := make([]ResponseTransactionsID, 0, len(page))
+		for _, txid := range page {
+			if txn, ok := s.w.Transaction(txid); ok {
+				txns = append(txns, responseTransaction(txn, s.w, s.memos))
+			}
+		}
+		writeJSON(w, txns)
+		return
+	}
+	writeJSON(w, page)
 }
 
 func (s *server) transactionsidHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var txid crypto.Hash
 	if err := txid.LoadString(ps.ByName("txid")); err != nil {
-		http.Error(w, "Invalid transaction ID: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidID, "Invalid transaction ID: "+err.Error())
 		return
 	}
-	txn, ok := s.w.Transaction(types.TransactionID(txid))
+	txn, ok := s.transaction(types.TransactionID(txid))
 	if !ok {
-		http.Error(w, "Transaction not found", http.StatusNotFound)
-		return
-	}
-	credit, debit := calculateFlows(txn, s.w)
-	writeJSON(w, ResponseTransactionsID{
-		Transaction: txn.Transaction,
-		BlockID:     txn.BlockID,
-		BlockHeight: txn.BlockHeight,
-		Timestamp:   txn.Timestamp,
-		FeePerByte:  txn.FeePerByte,
-		Credit:      credit,
-		Debit:       debit,
+		writeError(w, http.StatusNotFound, CodeNotFound, "Transaction not found")
+		return
+	}
+	writeJSON(w, txn)
+}
+
+func (s *server) txnfeeHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txn types.Transaction
+	if err := json.NewDecoder(req.Body).Decode(&txn); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse transaction: "+err.Error())
+		return
+	}
+	// account for signatures that have not yet been supplied
+	for i := range txn.TransactionSignatures {
+		if len(txn.TransactionSignatures[i].Signature) == 0 {
+			txn.TransactionSignatures[i].Signature = make([]byte, crypto.SignatureSize)
+		}
+	}
+	var minerFees types.Currency
+	for _, fee := range txn.MinerFees {
+		minerFees = minerFees.Add(fee)
+	}
+	// a transaction without a miner fee will need one before it is broadcast
+	if len(txn.MinerFees) == 0 {
+		txn.MinerFees = append(txn.MinerFees, maxCurrency)
+	}
+	size := uint64(txn.MarshalSiaSize())
+	fees := s.fees()
+	writeJSON(w, ResponseTxnFee{
+		Size:      size,
+		MinerFees: minerFees,
+		Economy:   fees.Economy.Mul64(size),
+		Normal:    fees.Normal.Mul64(size),
+		Urgent:    fees.Urgent.Mul64(size),
+	})
+}
+
+func (s *server) txnfundHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rtf RequestTxnFund
+	if err := json.NewDecoder(req.Body).Decode(&rtf); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse request: "+err.Error())
+		return
+	} else if len(rtf.Outputs) == 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "No outputs specified")
+		return
+	}
+	for _, sco := range rtf.Outputs {
+		if sco.Value.IsZero() {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "Outputs must have non-zero value")
+			return
+		}
+	}
+	if rtf.Reserve > 0 && !s.checkScope(w, req, ScopeBroadcast) {
+		return
+	}
+	reserve, err := reservationDuration(rtf.Reserve)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	feePerByte := rtf.FeePerByte
+	if feePerByte.IsZero() {
+		feePerByte, _ = s.tp.FeeEstimation()
+	}
+	resp, err := s.fundTransaction(types.Transaction{SiacoinOutputs: rtf.Outputs}, fundParams{
+		feePerByte: feePerByte,
+		changeAddr: rtf.ChangeAddress,
+		reserve:    reserve,
+		cc:         rtf.CoinControl,
+	})
+	if err == wallet.ErrInsufficientFunds {
+		writeError(w, http.StatusBadRequest, CodeInsufficientFunds, "Insufficient funds")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func (s *server) txnconsolidateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rtc RequestTxnConsolidate
+	if err := json.NewDecoder(req.Body).Decode(&rtc); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse request: "+err.Error())
+		return
+	} else if rtc.Address == (types.UnlockHash{}) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "No address specified")
+		return
+	}
+	if rtc.Reserve > 0 && !s.checkScope(w, req, ScopeBroadcast) {
+		return
+	}
+	reserve, err := reservationDuration(rtc.Reserve)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	minFee, maxFee := s.tp.FeeEstimation()
+	feePerByte := rtc.FeePerByte
+	if feePerByte.IsZero() {
+		feePerByte = minFee
+	} else if feePerByte.Cmp(maxFee) > 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Fee rate exceeds transaction pool maximum of "+maxFee.String())
+		return
+	}
+	maxSize := rtc.MaxSize
+	if maxSize == 0 || maxSize > modules.TransactionSizeLimit {
+		maxSize = modules.TransactionSizeLimit
+	}
+	txns, err := s.consolidate(consolidateParams{
+		addr:       rtc.Address,
+		addrs:      rtc.Addresses,
+		maxValue:   rtc.MaxValue,
+		maxSize:    maxSize,
+		feePerByte: feePerByte,
+		reserve:    reserve,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, txns)
+}
+
+func (s *server) txnsweepHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var rts RequestTxnSweep
+	if err := json.NewDecoder(req.Body).Decode(&rts); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse request: "+err.Error())
+		return
+	} else if rts.Address == (types.UnlockHash{}) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "No address specified")
+		return
+	}
+	if rts.Reserve > 0 && !s.checkScope(w, req, ScopeBroadcast) {
+		return
+	}
+	reserve, err := reservationDuration(rts.Reserve)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	feePerByte := rts.FeePerByte
+	if feePerByte.IsZero() {
+		feePerByte, _ = s.tp.FeeEstimation()
+	}
+	resp, err := s.sweep(sweepParams{
+		addr:       rts.Address,
+		addrs:      rts.Addresses,
+		feePerByte: feePerByte,
+		reserve:    reserve,
+		cc:         rts.CoinControl,
 	})
+	if err == wallet.ErrInsufficientFunds {
+		writeError(w, http.StatusBadRequest, CodeInsufficientFunds, "Insufficient funds")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, resp)
 }
 
 func (s *server) unconfirmedparentsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	var txn types.Transaction
 	if err := json.NewDecoder(req.Body).Decode(&txn); err != nil {
-		http.Error(w, "Could not parse transaction: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse transaction: "+err.Error())
 		return
 	}
 	writeJSON(w, wallet.UnconfirmedParents(txn, s.w.LimboTransactions()))
 }
 
+// unspentOutputs returns the wallet's unspent outputs, optionally excluding
+// reserved outputs. If limbo is true, the outputs reflect the transactions in
+// Limbo, ignoring any that have been invalidated by a confirmed transaction.
+func (s *server) unspentOutputs(limbo, excludeReserved bool) []wallet.UnspentOutput {
+	outputs := s.w.UnspentOutputs(false)
+	if limbo {
+		viable, _ := s.viableLimbo()
+		outputs = wallet.CalculateLimboOutputs(s.w, viable, outputs)
+	}
+	if excludeReserved {
+		filtered := outputs[:0]
+		for _, o := range outputs {
+			if !s.res.reserved(o.ID) {
+				filtered = append(filtered, o)
+			}
+		}
+		outputs = filtered
+	}
+	return outputs
+}
+
+// An outputOrigin records how an output was created: either by a
+// transaction, or as a block reward.
+type outputOrigin struct {
+	txid   types.TransactionID
+	reward bool
+	height types.BlockHeight // for rewards only
+}
+
+// An outputHeightCache records the origins of the wallet's outputs, so that
+// their creation heights can be determined without scanning the wallet's
+// history on every request. An output's origin never changes, so the cache is
+// never invalidated; transaction heights, which a reorg may alter, are read
+// from the wallet on each lookup.
+type outputHeightCache struct {
+	mu      sync.Mutex
+	origins map[types.SiacoinOutputID]outputOrigin
+	// scanned records the consensus change ID at which each address's
+	// history was last scanned, and rewardsScanned the same for block
+	// rewards, so that each is rescanned at most once per consensus change
+	scanned        map[types.UnlockHash]modules.ConsensusChangeID
+	rewardsScanned *modules.ConsensusChangeID
+}
+
+// lookup returns the creation heights of the supplied outputs. Outputs whose
+// origin is unknown (e.g. storage proof outputs) are omitted.
+func (c *outputHeightCache) lookup(w *wallet.SeedWallet, outputs []wallet.UnspentOutput) map[types.SiacoinOutputID]types.BlockHeight {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.origins == nil {
+		c.origins = make(map[types.SiacoinOutputID]outputOrigin)
+		c.scanned = make(map[types.UnlockHash]modules.ConsensusChangeID)
+	}
+	ccid := w.ConsensusChangeID()
+	for _, o := range outputs {
+		if _, ok := c.origins[o.ID]; ok {
+			continue
+		}
+		if at, ok := c.scanned[o.UnlockHash]; !ok || at != ccid {
+			c.scanned[o.UnlockHash] = ccid
+			for _, txid := range w.TransactionsByAddress(o.UnlockHash, -1) {
+				if txn, ok := w.Transaction(txid); ok {
+					for i := range txn.SiacoinOutputs {
+						c.origins[txn.SiacoinOutputID(uint64(i))] = outputOrigin{txid: txid}
+					}
+				}
+			}
+		}
+		if _, ok := c.origins[o.ID]; !ok && (c.rewardsScanned == nil || *c.rewardsScanned != ccid) {
+			c.rewardsScanned = &ccid
+			for _, br := range w.BlockRewards(-1) {
+				// a reward's timelock is its maturity height, i.e. the
+				// height of the block that created it plus the maturity
+				// delay
+				var height types.BlockHeight
+				if br.Timelock > types.MaturityDelay {
+					height = br.Timelock - types.MaturityDelay
+				}
+				c.origins[br.ID] = outputOrigin{reward: true, height: height}
+			}
+		}
+	}
+	heights := make(map[types.SiacoinOutputID]types.BlockHeight, len(outputs))
+	txnHeights := make(map[types.TransactionID]types.BlockHeight)
+	for _, o := range outputs {
+		origin, ok := c.origins[o.ID]
+		if !ok {
+			continue
+		} else if origin.reward {
+			heights[o.ID] = origin.height
+			continue
+		}
+		height, ok := txnHeights[origin.txid]
+		if !ok {
+			txn, ok := w.Transaction(origin.txid)
+			if !ok {
+				continue // reverted
+			}
+			height = txn.BlockHeight
+			txnHeights[origin.txid] = height
+		}
+		heights[o.ID] = height
+	}
+	return heights
+}
+
+// outputConfirmations returns the number of confirmations of each of the
+// supplied outputs. Outputs created by Limbo transactions, and outputs whose
+// origin is unknown (e.g. storage proof outputs), have zero confirmations.
+func (s *server) outputConfirmations(outputs []wallet.UnspentOutput) map[types.SiacoinOutputID]uint64 {
+	heights := s.heights.lookup(s.w, outputs)
+	confirmed := make(map[types.SiacoinOutputID]struct{})
+	for _, o := range s.w.UnspentOutputs(false) {
+		confirmed[o.ID] = struct{}{}
+	}
+	chainHeight := s.w.ChainHeight()
+	confs := make(map[types.SiacoinOutputID]uint64, len(outputs))
+	for _, o := range outputs {
+		height, known := heights[o.ID]
+		if _, ok := confirmed[o.ID]; !ok || !known {
+			confs[o.ID] = 0
+		} else if height > chainHeight {
+			confs[o.ID] = 1
+		} else {
+			confs[o.ID] = uint64(chainHeight-height) + 1
+		}
+	}
+	return confs
+}
+
+// An outputFilter restricts and orders the outputs returned by /utxos.
+type outputFilter struct {
+	addrs            map[types.UnlockHash]struct{}
+	minValue         types.Currency
+	maxValue         *types.Currency
+	minConfirmations uint64
+	sort             string
+}
+
+func parseOutputFilter(req *http.Request) (f outputFilter, err error) {
+	req.ParseForm()
+	f.addrs = make(map[types.UnlockHash]struct{})
+	for _, s := range req.Form["addr"] {
+		var addr types.UnlockHash
+		if err := addr.LoadString(s); err != nil {
+			return f, errors.New("Invalid address: " + err.Error())
+		}
+		f.addrs[addr] = struct{}{}
+	}
+	if v := req.FormValue("minValue"); v != "" {
+		if _, err := fmt.Sscan(v, &f.minValue); err != nil {
+			return f, errors.New("Invalid 'minValue' value: " + err.Error())
+		}
+	}
+	if v := req.FormValue("maxValue"); v != "" {
+		f.maxValue = new(types.Currency)
+		if _, err := fmt.Sscan(v, f.maxValue); err != nil {
+			return f, errors.New("Invalid 'maxValue' value: " + err.Error())
+		}
+	}
+	if v := req.FormValue("minConfirmations"); v != "" {
+		if f.minConfirmations, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, errors.New("Invalid 'minConfirmations' value: " + err.Error())
+		}
+	}
+	switch f.sort = req.FormValue("sort"); f.sort {
+	case "", "largest", "smallest", "oldest", "newest":
+	default:
+		return f, errors.New("Invalid 'sort' value: must be one of 'largest', 'smallest', 'oldest', or 'newest'")
+	}
+	return f, nil
+}
+
+// apply returns the outputs that match the filter, in the requested order.
+func (f outputFilter) apply(outputs []wallet.UnspentOutput, confirmations func() map[types.SiacoinOutputID]uint64) []wallet.UnspentOutput {
+	var confs map[types.SiacoinOutputID]uint64
+	if f.minConfirmations > 0 || f.sort == "oldest" || f.sort == "newest" {
+		confs = confirmations()
+	}
+	filtered := outputs[:0]
+	for _, o := range outputs {
+		if _, ok := f.addrs[o.UnlockHash]; !ok && len(f.addrs) > 0 {
+			continue
+		} else if o.Value.Cmp(f.minValue) < 0 || (f.maxValue != nil && o.Value.Cmp(*f.maxValue) > 0) {
+			continue
+		} else if f.minConfirmations > 0 && confs[o.ID] < f.minConfirmations {
+			continue
+		}
+		filtered = append(filtered, o)
+	}
+	less := map[string]func(a, b wallet.UnspentOutput) bool{
+		"largest":  func(a, b wallet.UnspentOutput) bool { return a.Value.Cmp(b.Value) > 0 },
+		"smallest": func(a, b wallet.UnspentOutput) bool { return a.Value.Cmp(b.Value) < 0 },
+		"oldest":   func(a, b wallet.UnspentOutput) bool { return confs[a.ID] > confs[b.ID] },
+		"newest":   func(a, b wallet.UnspentOutput) bool { return confs[a.ID] < confs[b.ID] },
+	}[f.sort]
+	if less != nil {
+		sort.Slice(filtered, func(i, j int) bool {
+			a, b := filtered[i], filtered[j]
+			if less(a, b) || less(b, a) {
+				return less(a, b)
+			}
+			return bytes.Compare(a.ID[:], b.ID[:]) < 0
+		})
+	}
+	return filtered
+}
+
+func (s *server) validateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txnSet []types.Transaction
+	if err := json.NewDecoder(req.Body).Decode(&txnSet); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Could not parse transaction: "+err.Error())
+		return
+	} else if len(txnSet) == 0 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Transaction set is empty")
+		return
+	}
+	writeJSON(w, s.validateTransactionSet(txnSet))
+}
+
 func (s *server) utxosHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	writeJSON(w, s.w.UnspentOutputs(req.FormValue("limbo") == "true"))
+	filter, err := parseOutputFilter(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	outputs := s.unspentOutputs(req.FormValue("limbo") == "true", req.FormValue("excludeReserved") == "true")
+	writeJSON(w, filter.apply(outputs, func() map[types.SiacoinOutputID]uint64 {
+		return s.outputConfirmations(outputs)
+	}))
 }
 
 // NewServer returns an HTTP handler that serves the walrus API.
-func NewServer(w *wallet.SeedWallet, tp TransactionPool) http.Handler {
-	s := server{
-		w:  w,
-		tp: tp,
+func NewServer(w *wallet.SeedWallet, tp TransactionPool, opts ...ServerOption) http.Handler {
+	s := &server{
+		w:   w,
+		tp:  tp,
+		res: newReservationSet(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.memos == nil {
+		s.memos, _ = NewMemoStore(w, MemoOptions{}) // cannot fail without a Path
+	}
+	if s.events != nil {
+		s.events.setMemos(s.memos)
+	}
+	if s.labels == nil {
+		s.labels, _ = NewLabelStore(LabelOptions{})
 	}
 	mux := httprouter.New()
-	mux.GET("/addresses", s.addressesHandler)
-	mux.POST("/addresses", s.addressesHandlerPOST)
-	mux.GET("/addresses/:addr", s.addressesaddrHandlerGET)
-	mux.DELETE("/addresses/:addr", s.addressesaddrHandlerDELETE)
-	mux.GET("/balance", s.balanceHandler)
-	mux.POST("/batchquery/:endpoint", s.batchqueryHandler)
-	mux.GET("/blockrewards", s.blockrewardsHandler)
-	mux.POST("/broadcast", s.broadcastHandler)
-	mux.GET("/consensus", s.consensusHandler)
-	mux.GET("/fee", s.feeHandler)
-	mux.GET("/filecontracts", s.filecontractsHandler)
-	mux.GET("/filecontracts/:id", s.filecontractsidHandler)
-	mux.PUT("/limbo/:id", s.limboHandlerPUT)
-	mux.GET("/limbo", s.limboHandler)
-	mux.DELETE("/limbo/:id", s.limboHandlerDELETE)
-	mux.PUT("/memos/:txid", s.memosHandlerPUT)
-	mux.GET("/memos/:txid", s.memosHandlerGET)
-	mux.GET("/seedindex", s.seedindexHandler)
-	mux.GET("/transactions", s.transactionsHandler)
-	mux.GET("/transactions/:txid", s.transactionsidHandler)
-	mux.POST("/unconfirmedparents", s.unconfirmedparentsHandler)
-	mux.GET("/utxos", s.utxosHandler)
+	mux.GET("/addresses", s.authorize(ScopeRead, s.addressesHandler))
+	mux.POST("/addresses", s.authorize(ScopeAddresses, s.addressesHandlerPOST))
+	mux.GET("/addresses/:addr", s.authorize(ScopeRead, s.addressesaddrHandlerGET))
+	mux.PUT("/addresses/:addr", s.authorize(ScopeAddresses, s.addressesaddrHandlerPUT))
+	mux.DELETE("/addresses/:addr", s.authorize(ScopeAddresses, s.addressesaddrHandlerDELETE))
+	mux.GET("/balance", s.authorize(ScopeRead, s.balanceHandler))
+	mux.POST("/batchquery/:endpoint", s.authorize(ScopeRead, s.batchqueryHandler))
+	mux.GET("/blockrewards", s.authorize(ScopeRead, s.blockrewardsHandler))
+	mux.POST("/broadcast", s.authorize(ScopeBroadcast, s.broadcastHandler))
+	mux.GET("/consensus", s.authorize(ScopeRead, s.consensusHandler))
+	mux.GET("/events", s.authorize(ScopeRead, s.eventsHandler))
+	mux.GET("/fee", s.authorize(ScopeRead, s.feeHandler))
+	mux.GET("/filecontracts", s.authorize(ScopeRead, s.filecontractsHandler))
+	mux.GET("/filecontracts/:id", s.authorize(ScopeRead, s.filecontractsidHandler))
+	mux.PUT("/limbo/:id", s.authorize(ScopeBroadcast, s.limboHandlerPUT))
+	mux.GET("/limbo", s.authorize(ScopeRead, s.limboHandler))
+	mux.DELETE("/limbo/:id", s.authorize(ScopeBroadcast, s.limboHandlerDELETE))
+	mux.GET("/memos", s.authorize(ScopeRead, s.memosHandler))
+	mux.GET("/memosearch", s.authorize(ScopeRead, s.memosearchHandler))
+	mux.PUT("/memos/:txid", s.authorize(ScopeMemos, s.memosHandlerPUT))
+	mux.GET("/memos/:txid", s.authorize(ScopeRead, s.memosHandlerGET))
+	mux.DELETE("/memos/:txid", s.authorize(ScopeMemos, s.memosHandlerDELETE))
+	mux.GET("/reservations", s.authorize(ScopeRead, s.reservationsHandler))
+	mux.POST("/reservations", s.authorize(ScopeBroadcast, s.reservationsHandlerPOST))
+	mux.DELETE("/reservations/:id", s.authorize(ScopeBroadcast, s.reservationsidHandlerDELETE))
+	mux.GET("/seedindex", s.authorize(ScopeRead, s.seedindexHandler))
+	mux.GET("/transactions", s.authorize(ScopeRead, s.transactionsHandler))
+	mux.GET("/transactions/:txid", s.authorize(ScopeRead, s.transactionsidHandler))
+	mux.POST("/txn/consolidate", s.authorize(ScopeRead, s.txnconsolidateHandler))
+	mux.POST("/txn/fee", s.authorize(ScopeRead, s.txnfeeHandler))
+	mux.POST("/txn/fund", s.authorize(ScopeRead, s.txnfundHandler))
+	mux.POST("/txn/sweep", s.authorize(ScopeRead, s.txnsweepHandler))
+	mux.POST("/unconfirmedparents", s.authorize(ScopeRead, s.unconfirmedparentsHandler))
+	mux.GET("/utxos", s.authorize(ScopeRead, s.utxosHandler))
+	mux.POST("/validate", s.authorize(ScopeRead, s.validateHandler))
+
+	if s.cors != nil {
+		mux.GlobalOPTIONS = http.HandlerFunc(s.corsPreflight)
+		return s.corsHandler(mux)
+	}
 	return mux
 }
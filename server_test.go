@@ -1,12 +1,20 @@
 package walrus
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +22,7 @@ import (
 	"go.sia.tech/siad/modules"
 	"go.sia.tech/siad/types"
 	"lukechampine.com/frand"
+	"lukechampine.com/us/ed25519hash"
 	"lukechampine.com/us/wallet"
 )
 
@@ -23,6 +32,13 @@ func (stubTpool) AcceptTransactionSet([]types.Transaction) (err error)   { retur
 func (stubTpool) FeeEstimation() (min, max types.Currency)               { return }
 func (stubTpool) TransactionSet(id crypto.Hash) (ts []types.Transaction) { return }
 
+type feeTpool struct {
+	stubTpool
+	min, max types.Currency
+}
+
+func (tp feeTpool) FeeEstimation() (min, max types.Currency) { return tp.min, tp.max }
+
 type mockCS struct {
 	subscriber modules.ConsensusSetSubscriber
 	utxos      map[types.SiacoinOutputID]types.SiacoinOutput
@@ -110,7 +126,12 @@ func runServer(h http.Handler) (*Client, func() error) {
 	}
 	srv := http.Server{Handler: h}
 	go srv.Serve(l)
-	return NewClient("http://" + l.Addr().String()), srv.Close
+	return NewClient("http://" + l.Addr().String()), func() error {
+		// prevent subsequent servers on the same port from receiving
+		// requests on stale connections
+		http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+		return srv.Close()
+	}
 }
 
 func TestServer(t *testing.T) {
@@ -419,3 +440,2366 @@ func TestServerThreadSafety(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestServerAuth(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	srv := NewServer(w, stubTpool{},
+		AuthPassword("foo"),
+		AuthToken("bar", ScopeRead),
+	)
+	client, stop := runServer(srv)
+	defer stop()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+
+	// unauthenticated requests should fail
+	if _, err := client.Balance(false); !errors.Is(err, ErrUnauthorized) {
+		t.Fatal("expected unauthenticated request to fail, got", err)
+	}
+
+	// password grants all scopes
+	client = NewClient(client.eps.addrs[0], WithPassword("foo"))
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if err := client.AddAddress(info); err != nil {
+		t.Fatal(err)
+	}
+
+	// token only grants read scope
+	client = NewClient(client.eps.addrs[0], WithToken("bar"))
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if err := client.RemoveAddress(info.UnlockHash()); !errors.Is(err, ErrForbidden) {
+		t.Fatal("expected read-only token to be rejected, got", err)
+	} else if err := client.Broadcast([]types.Transaction{{}}); err == nil {
+		t.Fatal("expected read-only token to be rejected")
+	}
+
+	// wrong credentials should fail
+	client = NewClient(client.eps.addrs[0], WithToken("foo"))
+	if _, err := client.Balance(false); err == nil {
+		t.Fatal("expected password to be rejected as a token")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	tp := new(recordingTpool)
+	client, stop := runServer(NewServer(w, tp))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+	}
+	w.AddAddress(info)
+	cs.sendTxn(types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.SiacoinPrecision}},
+	})
+
+	// errors should be decoded into an *Error with the appropriate code
+	_, err := client.AddressInfo(types.UnlockHash{1})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected not_found, got", err)
+	} else if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound || e.Message != "No such entry" {
+		t.Fatal("wrong error:", err)
+	}
+	if _, err := client.Transaction(types.TransactionID{1}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected not_found, got", err)
+	}
+	if err := client.get("/addresses/foo", nil); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatal("expected invalid_address, got", err)
+	}
+	if err := client.get("/transactions/foo", nil); !errors.Is(err, ErrInvalidID) {
+		t.Fatal("expected invalid_id, got", err)
+	}
+	_, err = client.FundTransaction(RequestTxnFund{
+		Outputs: []types.SiacoinOutput{{UnlockHash: types.UnlockHash{1}, Value: types.SiacoinPrecision.Mul64(2)}},
+	})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatal("expected insufficient_funds, got", err)
+	}
+
+	// tpool errors should be distinguished by cause
+	txn := types.Transaction{MinerFees: []types.Currency{types.NewCurrency64(1)}}
+	tp.err = errors.New("transaction set needs more miner fees to be accepted")
+	if err := client.Broadcast([]types.Transaction{txn}); !errors.Is(err, ErrInsufficientFee) {
+		t.Fatal("expected insufficient_fee, got", err)
+	}
+	tp.err = errors.New("transaction pool is full")
+	if err := client.Broadcast([]types.Transaction{txn}); !errors.Is(err, ErrTpoolRejected) {
+		t.Fatal("expected tpool_rejected, got", err)
+	} else if err.Error() != "transaction pool is full" {
+		t.Fatal("wrong error message:", err)
+	}
+
+	// ProtoWallet should skip inputs it does not own, but report other errors
+	pw := client.ProtoWallet(seed)
+	txn = types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{UnlockConditions: types.UnlockConditions{Timelock: 1}}},
+	}
+	if err := pw.SignTransaction(&txn, nil); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if err := pw.SignTransaction(&txn, nil); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatal("expected connection error, got", err)
+	}
+}
+
+// non-JSON error bodies (e.g. from a proxy) should still produce an *Error
+func TestDecodeError(t *testing.T) {
+	e := decodeError(http.StatusBadGateway, []byte("bad gateway\n"))
+	if e.Code != CodeInternal || e.Message != "bad gateway" || e.StatusCode != http.StatusBadGateway {
+		t.Fatal("wrong error:", e)
+	}
+	e = decodeError(http.StatusNotFound, []byte("404 page not found\n"))
+	if !errors.Is(e, ErrNotFound) {
+		t.Fatal("wrong error:", e)
+	}
+}
+
+func TestPinnedCertificate(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	srv := httptest.NewTLSServer(NewServer(w, stubTpool{}))
+	defer srv.Close()
+	fingerprint := CertificateFingerprint(srv.Certificate().Raw)
+
+	// without pinning, the self-signed certificate should be rejected
+	if _, err := NewClient(srv.URL).Balance(false); err == nil {
+		t.Fatal("expected self-signed certificate to be rejected")
+	}
+	// with the wrong fingerprint, the certificate should be rejected
+	if _, err := NewClient(srv.URL, WithPinnedCertificate(CertificateFingerprint(nil))).Balance(false); err == nil {
+		t.Fatal("expected mismatched certificate to be rejected")
+	}
+	// with the correct fingerprint, the certificate should be accepted
+	if _, err := NewClient(srv.URL, WithPinnedCertificate(strings.ToUpper(fingerprint))).Balance(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	var gotHeader atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotHeader.Store(req.Header.Get("X-Request-Id"))
+		if req.URL.Query().Get("limbo") == "true" {
+			// simulate a slow server
+			select {
+			case <-req.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		NewServer(w, stubTpool{}).ServeHTTP(rw, req)
+	}))
+	defer srv.Close()
+
+	// custom headers should be sent with every request
+	client := NewClient(srv.URL, WithHeader("X-Request-Id", "foo"))
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if gotHeader.Load() != "foo" {
+		t.Fatal("custom header was not sent")
+	}
+
+	// a canceled context should abort the request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.WithContext(ctx).Balance(true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded, got", err)
+	} else if time.Since(start) > time.Second {
+		t.Fatal("request was not aborted")
+	}
+	// the original client should be unaffected
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// timeouts should apply to every request, without modifying the
+	// supplied http.Client
+	hc := &http.Client{}
+	client = NewClient(srv.URL, WithHTTPClient(hc), WithTimeout(10*time.Millisecond))
+	if _, err := client.Balance(true); err == nil {
+		t.Fatal("expected timeout")
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatal("expected timeout, got", err)
+	} else if hc.Timeout != 0 {
+		t.Fatal("supplied http.Client was modified")
+	}
+
+	// a custom transport should be used
+	var used bool
+	client = NewClient(srv.URL, WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(req)
+	})))
+	if _, err := client.Balance(false); err != nil {
+		t.Fatal(err)
+	} else if !used {
+		t.Fatal("custom transport was not used")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
+
+func TestServerCORS(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	srv := httptest.NewServer(NewServer(w, stubTpool{},
+		AuthPassword("foo"),
+		CORS(CORSOptions{AllowedOrigins: []string{"https://wallet.example.com"}}),
+	))
+	defer srv.Close()
+
+	do := func(method, route, origin string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+route, nil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+			req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// preflight from a permitted origin should succeed without credentials
+	resp := do("OPTIONS", "/addresses/foo", "https://wallet.example.com")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatal("unexpected preflight status:", resp.Status)
+	} else if resp.Header.Get("Access-Control-Allow-Origin") != "https://wallet.example.com" {
+		t.Fatal("missing Access-Control-Allow-Origin")
+	} else if methods := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "DELETE") || !strings.Contains(methods, "GET") {
+		t.Fatal("wrong Access-Control-Allow-Methods:", methods)
+	} else if !strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Fatal("missing Authorization in Access-Control-Allow-Headers")
+	}
+
+	// preflight from another origin should not be permitted
+	resp = do("OPTIONS", "/balance", "https://evil.example.com")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("unexpected Access-Control-Allow-Origin")
+	}
+
+	// normal requests should carry the header too, even if they fail
+	resp = do("GET", "/balance", "https://wallet.example.com")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expected request to be rejected:", resp.Status)
+	} else if resp.Header.Get("Access-Control-Allow-Origin") != "https://wallet.example.com" {
+		t.Fatal("missing Access-Control-Allow-Origin")
+	}
+	// browser clients must be able to read the pagination and consensus
+	// headers
+	exposed := resp.Header.Get("Access-Control-Expose-Headers")
+	for _, h := range []string{NextCursorHeader, ConsensusHeightHeader, ConsensusIDHeader} {
+		if !strings.Contains(exposed, h) {
+			t.Fatalf("%v missing from Access-Control-Expose-Headers", h)
+		}
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}, Events(hub)))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+
+	sub, err := client.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	nextEvent := func() Event {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				t.Fatal("subscription closed:", sub.Err())
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		panic("unreachable")
+	}
+
+	// an incoming payment should trigger both a new and confirmed event
+	payment := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(payment)
+	if e := nextEvent(); e.Type != EventNewTransaction || *e.TransactionID != payment.ID() {
+		t.Fatal("expected new transaction event, got", e.Type)
+	} else if !e.Transaction.Credit.Equals(types.SiacoinPrecision) {
+		t.Fatal("wrong credit in event:", e.Transaction.Credit)
+	}
+	if e := nextEvent(); e.Type != EventTransactionConfirmed || *e.TransactionID != payment.ID() {
+		t.Fatal("expected confirmed event, got", e.Type)
+	}
+
+	// broadcasting should place the transaction in limbo
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         payment.SiacoinOutputID(0),
+			UnlockConditions: info.UnlockConditions,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.SiacoinPrecision}},
+	}
+	if err := client.Broadcast([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(); e.Type != EventLimboAdded || *e.TransactionID != txn.ID() {
+		t.Fatal("expected limbo event, got", e.Type)
+	}
+
+	// once mined, the transaction should be confirmed and leave limbo, but
+	// should not be reported as new
+	cs.sendTxn(txn)
+	if e := nextEvent(); e.Type != EventTransactionConfirmed || *e.TransactionID != txn.ID() {
+		t.Fatal("expected confirmed event, got", e.Type)
+	}
+	if e := nextEvent(); e.Type != EventLimboRemoved || *e.TransactionID != txn.ID() {
+		t.Fatal("expected limbo removal event, got", e.Type)
+	}
+
+	// filtered subscriptions should only receive the requested types
+	sub2, err := client.Subscribe(EventFileContract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub2.Close()
+	cs.sendTxn(types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+		FileContracts: []types.FileContract{{
+			FileMerkleRoot:    crypto.Hash{1, 2, 3},
+			ValidProofOutputs: []types.SiacoinOutput{{UnlockHash: addr}},
+		}},
+	})
+	select {
+	case e := <-sub2.Events():
+		if e.Type != EventFileContract || e.FileContract.FileMerkleRoot != (crypto.Hash{1, 2, 3}) {
+			t.Fatal("expected file contract event, got", e.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// invalid types should be rejected
+	if _, err := client.Subscribe("foo"); err == nil {
+		t.Fatal("expected invalid event type to be rejected")
+	}
+}
+
+func TestSubscriptionMultilineData(t *testing.T) {
+	// an event split across several data fields should be reassembled with
+	// newlines; without them, the second event would decode as height 12
+	stream := "data: {\"type\": \"newTransaction\",\ndata: \"height\": 7}\n\n" +
+		"data: {\"type\": \"newTransaction\", \"height\": 1\ndata: 2}\n\n"
+	sub := &Subscription{
+		ch:     make(chan Event),
+		body:   ioutil.NopCloser(strings.NewReader(stream)),
+		closed: make(chan struct{}),
+	}
+	go sub.run()
+	if e := <-sub.Events(); e.Type != EventNewTransaction || e.Height != 7 {
+		t.Fatal("wrong event:", e)
+	}
+	if e, ok := <-sub.Events(); ok {
+		t.Fatal("expected malformed event to be rejected, got", e)
+	} else if sub.Err() == nil {
+		t.Fatal("expected decoding error")
+	}
+}
+
+func TestServerPagination(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+	for i := 0; i < 5; i++ {
+		cs.sendTxn(types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.NewCurrency64(uint64(i + 1))}},
+			FileContracts: []types.FileContract{{
+				FileSize:          uint64(i),
+				ValidProofOutputs: []types.SiacoinOutput{{UnlockHash: addr}},
+			}},
+		})
+	}
+	all, err := client.Transactions(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages [][]types.TransactionID
+	it := client.TransactionIterator(TransactionQuery{Limit: 2})
+	for it.Next() {
+		pages = append(pages, it.Page())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	} else if len(pages) != 3 || len(pages[0]) != 2 || len(pages[1]) != 2 || len(pages[2]) != 1 {
+		t.Fatal("wrong page sizes:", pages)
+	}
+	var paged []types.TransactionID
+	for _, p := range pages {
+		paged = append(paged, p...)
+	}
+	for i := range all {
+		if paged[i] != all[i] {
+			t.Fatal("paginated transactions do not match full listing")
+		}
+	}
+
+	// an address filter should be respected
+	if txids, next, err := client.TransactionsPage(TransactionQuery{Limit: 10, Address: &addr}); err != nil {
+		t.Fatal(err)
+	} else if len(txids) != 5 || next != "" {
+		t.Fatal("expected a single page of 5 transactions")
+	}
+
+	// a limit of 0 should select the default page size
+	if txids, next, err := client.TransactionsPage(TransactionQuery{}); err != nil {
+		t.Fatal(err)
+	} else if len(txids) != len(all) || next != "" {
+		t.Fatal("expected a single page of all transactions, got", len(txids))
+	}
+
+	// if the listing has shifted since a cursor was issued, the cursor should
+	// still identify the same transaction
+	if txids, _, err := client.TransactionsPage(TransactionQuery{Cursor: encodeCursor(0, all[2].String()), Limit: -1}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(txids, all[3:]) {
+		t.Fatal("stale cursor resolved to the wrong page")
+	}
+
+	// invalid cursors should be rejected
+	if _, _, err := client.TransactionsPage(TransactionQuery{Cursor: "foo", Limit: 2}); err == nil {
+		t.Fatal("expected invalid cursor to be rejected")
+	}
+
+	// file contracts
+	var fcs []wallet.FileContract
+	fit := client.FileContractIterator(3)
+	for fit.Next() {
+		fcs = append(fcs, fit.Page()...)
+	}
+	if err := fit.Err(); err != nil {
+		t.Fatal(err)
+	} else if len(fcs) != 5 {
+		t.Fatal("expected 5 contracts, got", len(fcs))
+	}
+	seen := make(map[types.FileContractID]bool)
+	for _, fc := range fcs {
+		if seen[fc.ID] {
+			t.Fatal("duplicate contract in iteration")
+		}
+		seen[fc.ID] = true
+	}
+
+	// block rewards (none)
+	if rewards, next, err := client.BlockRewardsPage("", 10); err != nil {
+		t.Fatal(err)
+	} else if len(rewards) != 0 || next != "" {
+		t.Fatal("expected no block rewards")
+	}
+}
+
+func TestServerTransactionFilters(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+
+	// three incoming transactions, followed by one outgoing transaction
+	var txns []types.Transaction
+	for _, v := range []uint64{1, 5, 10} {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.NewCurrency64(v)}},
+		}
+		cs.sendTxn(txn)
+		txns = append(txns, txn)
+	}
+	out := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         txns[2].SiacoinOutputID(0),
+			UnlockConditions: info.UnlockConditions,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.NewCurrency64(10)}},
+	}
+	cs.sendTxn(out)
+	txns = append(txns, out)
+
+	tests := []struct {
+		q   TransactionQuery
+		exp []int
+	}{
+		{TransactionQuery{Direction: DirectionIncoming}, []int{0, 1, 2}},
+		{TransactionQuery{Direction: DirectionOutgoing}, []int{3}},
+		{TransactionQuery{MinAmount: types.NewCurrency64(5)}, []int{1, 2, 3}},
+		{TransactionQuery{Direction: DirectionIncoming, MinAmount: types.NewCurrency64(5)}, []int{1, 2}},
+		{TransactionQuery{MinHeight: 2, MaxHeight: 2}, []int{2}},
+		{TransactionQuery{Since: time.Unix(1, 0)}, nil},
+		{TransactionQuery{Until: time.Unix(1, 0)}, []int{0, 1, 2, 3}},
+	}
+	for _, test := range tests {
+		test.q.Limit = -1
+		txids, _, err := client.TransactionsPage(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[types.TransactionID]bool)
+		for _, txid := range txids {
+			got[txid] = true
+		}
+		if len(got) != len(test.exp) {
+			t.Errorf("%+v: expected %v transactions, got %v", test.q, len(test.exp), len(got))
+			continue
+		}
+		for _, i := range test.exp {
+			if !got[txns[i].ID()] {
+				t.Errorf("%+v: missing transaction %v", test.q, i)
+			}
+		}
+	}
+
+	// filters should compose with pagination
+	var n int
+	it := client.TransactionIterator(TransactionQuery{Limit: 1, Direction: DirectionIncoming})
+	for it.Next() {
+		n += len(it.Page())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal("expected 3 incoming transactions, got", n)
+	}
+
+	// invalid filters should be rejected
+	for _, q := range []string{"direction=sideways", "minHeight=-1", "since=yesterday", "minAmount=foo"} {
+		var txids []types.TransactionID
+		if err := client.get("/transactions?"+q, &txids); err == nil {
+			t.Error("expected error for", q)
+		}
+	}
+}
+
+func TestServerTransactionHistory(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+	var txns []types.Transaction
+	for i := 0; i < 3; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.NewCurrency64(uint64(i + 1))}},
+			MinerFees:      []types.Currency{types.NewCurrency64(uint64(i)), types.NewCurrency64(1)},
+		}
+		cs.sendTxn(txn)
+		txns = append(txns, txn)
+	}
+	w.SetMemo(txns[0].ID(), []byte("foo"))
+
+	txids, _, err := client.TransactionsPage(TransactionQuery{Limit: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []ResponseTransactionsID
+	q := TransactionQuery{Limit: 2}
+	for {
+		page, next, err := client.TransactionHistory(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, page...)
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	if len(history) != len(txids) {
+		t.Fatalf("expected %v records, got %v", len(txids), len(history))
+	}
+	for i, rt := range history {
+		if rt.Transaction.ID() != txids[i] {
+			t.Fatal("history does not match IDs")
+		}
+		exp, _ := client.Transaction(txids[i])
+		if !reflect.DeepEqual(rt, exp) {
+			t.Fatal("history record does not match /transactions/:txid")
+		}
+		if rt.Confirmations == 0 {
+			t.Error("expected non-zero confirmations")
+		}
+		for j, txn := range txns {
+			if txn.ID() != txids[i] {
+				continue
+			}
+			if !rt.Fee.Equals64(uint64(j + 1)) {
+				t.Errorf("expected fee of %v, got %v", j+1, rt.Fee)
+			}
+			if (j == 0) != (rt.Memo == "foo") {
+				t.Errorf("wrong memo for transaction %v: %q", j, rt.Memo)
+			}
+		}
+	}
+}
+
+func TestServerTransactionStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	revertedPath := filepath.Join(dir, "reverted.json")
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{RevertedPath: revertedPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}, Events(hub)))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+
+	checkStatus := func(txid types.TransactionID, status TransactionStatus, confirmations uint64) ResponseTransactionsID {
+		t.Helper()
+		txn, err := client.Transaction(txid)
+		if err != nil {
+			t.Fatal(err)
+		} else if txn.Status != status || txn.Confirmations != confirmations {
+			t.Fatalf("expected %v with %v confirmations, got %v with %v", status, confirmations, txn.Status, txn.Confirmations)
+		}
+		return txn
+	}
+
+	cs.sendTxn(types.Transaction{}) // genesis block
+	txn1 := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.NewCurrency64(10)}},
+	}
+	cs.sendTxn(txn1)
+	checkStatus(txn1.ID(), StatusConfirmed, 1)
+	txn2 := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.NewCurrency64(20)}},
+	}
+	cs.sendTxn(txn2)
+	checkStatus(txn1.ID(), StatusConfirmed, 2)
+	checkStatus(txn2.ID(), StatusConfirmed, 1)
+
+	// a transaction spending txn1 should be reported as limbo, with its debit
+	// computed from the wallet's outputs
+	spend := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         txn1.SiacoinOutputID(0),
+			UnlockConditions: info.UnlockConditions,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.NewCurrency64(9)}},
+		MinerFees:      []types.Currency{types.NewCurrency64(1)},
+	}
+	if err := client.AddToLimbo(spend); err != nil {
+		t.Fatal(err)
+	}
+	if ltxn := checkStatus(spend.ID(), StatusLimbo, 0); !ltxn.Debit.Equals64(10) || !ltxn.Fee.Equals64(1) {
+		t.Fatal("wrong debit or fee for limbo transaction:", ltxn.Debit, ltxn.Fee)
+	}
+
+	// revert the block containing txn2
+	cs.subscriber.ProcessConsensusChange(modules.ConsensusChange{
+		RevertedBlocks: []types.Block{{
+			Transactions: []types.Transaction{txn2},
+		}},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{
+			SiacoinOutputDiffs: []modules.SiacoinOutputDiff{{
+				Direction:     modules.DiffRevert,
+				SiacoinOutput: txn2.SiacoinOutputs[0],
+				ID:            txn2.SiacoinOutputID(0),
+			}},
+		},
+	})
+	checkStatus(txn2.ID(), StatusReverted, 0)
+	checkStatus(txn1.ID(), StatusConfirmed, 1)
+
+	// the reverted status should survive a restart
+	hub2, err := NewEventHub(w, EventHubOptions{RevertedPath: revertedPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewServer(w, stubTpool{}, Events(hub2)))
+	defer srv.Close()
+	if txn, err := NewClient(srv.URL).Transaction(txn2.ID()); err != nil {
+		t.Fatal(err)
+	} else if txn.Status != StatusReverted {
+		t.Fatal("expected reverted status after restart, got", txn.Status)
+	}
+
+	// re-applying the transaction should restore its status
+	cs.sendTxn(txn2)
+	checkStatus(txn2.ID(), StatusConfirmed, 1)
+
+	// a reorg that reverts and re-applies the transaction in a single change
+	// should leave it confirmed, including after a restart
+	outputDiff := func(dir modules.DiffDirection) modules.SiacoinOutputDiff {
+		return modules.SiacoinOutputDiff{
+			Direction:     dir,
+			SiacoinOutput: txn2.SiacoinOutputs[0],
+			ID:            txn2.SiacoinOutputID(0),
+		}
+	}
+	cs.subscriber.ProcessConsensusChange(modules.ConsensusChange{
+		RevertedBlocks: []types.Block{{Transactions: []types.Transaction{txn2}}},
+		AppliedBlocks:  []types.Block{{Transactions: []types.Transaction{txn2}}},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{
+			SiacoinOutputDiffs: []modules.SiacoinOutputDiff{
+				outputDiff(modules.DiffRevert),
+				outputDiff(modules.DiffApply),
+			},
+		},
+	})
+	checkStatus(txn2.ID(), StatusConfirmed, 1)
+	if hub3, err := NewEventHub(w, EventHubOptions{RevertedPath: revertedPath}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := hub3.revertedTransaction(txn2.ID()); ok {
+		t.Fatal("re-applied transaction should not be persisted as reverted")
+	}
+
+	if _, err := client.Transaction(types.TransactionID{1}); err == nil {
+		t.Fatal("expected unknown transaction to be rejected")
+	}
+}
+
+func TestEventHubMaxReverted(t *testing.T) {
+	w := wallet.New(wallet.NewEphemeralStore())
+	hub, err := NewEventHub(w, EventHubOptions{MaxReverted: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txns := make([]wallet.Transaction, 3)
+	for i := range txns {
+		txns[i].ArbitraryData = [][]byte{{byte(i)}}
+		hub.updateReverted(txns[i:i+1], nil)
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := hub.revertedTransaction(txns[0].ID()); ok {
+		t.Fatal("least recently reverted transaction should have been pruned")
+	}
+	for _, txn := range txns[1:] {
+		if _, ok := hub.revertedTransaction(txn.ID()); !ok {
+			t.Fatal("recently reverted transaction should be retained")
+		}
+	}
+	hub.updateReverted(nil, txns[1:2])
+	if _, ok := hub.revertedTransaction(txns[1].ID()); ok {
+		t.Fatal("re-applied transaction should be forgotten")
+	}
+}
+
+func TestServerLimboConflicts(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}, Events(hub)))
+	defer stop()
+	events, unsubscribe := hub.Subscribe(EventLimboConflict)
+	defer unsubscribe()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+		KeyIndex:         0,
+	}
+	w.AddAddress(info)
+	addr := info.UnlockHash()
+	cs.sendTxn(types.Transaction{})
+	funding := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(funding)
+
+	// add a transaction and its child to Limbo
+	input := types.SiacoinInput{
+		ParentID:         funding.SiacoinOutputID(0),
+		UnlockConditions: info.UnlockConditions,
+	}
+	parent := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}
+	child := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         parent.SiacoinOutputID(0),
+			UnlockConditions: info.UnlockConditions,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: types.UnlockHash{1}, Value: types.SiacoinPrecision}},
+	}
+	for _, txn := range []types.Transaction{parent, child} {
+		if err := client.AddToLimbo(txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type limboConflict struct {
+		ID            types.TransactionID  `json:"id"`
+		ConflictingID *types.TransactionID `json:"conflictingID"`
+	}
+	checkConflicts := func(exp *types.TransactionID) {
+		t.Helper()
+		var limbo []limboConflict
+		if err := client.get("/limbo", &limbo); err != nil {
+			t.Fatal(err)
+		} else if len(limbo) != 2 {
+			t.Fatal("expected 2 limbo transactions, got", len(limbo))
+		}
+		for _, txn := range limbo {
+			if (txn.ConflictingID == nil) != (exp == nil) || (exp != nil && *txn.ConflictingID != *exp) {
+				t.Fatalf("wrong conflict for %v: expected %v, got %v", txn.ID, exp, txn.ConflictingID)
+			}
+		}
+	}
+	checkConflicts(nil)
+
+	// confirm a different transaction spending the same input
+	conflict := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: types.UnlockHash{2}, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(conflict)
+	conflictID := conflict.ID()
+	checkConflicts(&conflictID)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if *e.ConflictingID != conflictID {
+				t.Fatal("wrong conflicting ID in event")
+			} else if *e.TransactionID != parent.ID() && *e.TransactionID != child.ID() {
+				t.Fatal("wrong transaction ID in event")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing limboConflict event")
+		}
+	}
+
+	// reverting the conflicting transaction should clear the conflict
+	cs.subscriber.ProcessConsensusChange(modules.ConsensusChange{
+		RevertedBlocks: []types.Block{{
+			Transactions: []types.Transaction{conflict},
+		}},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{
+			SiacoinOutputDiffs: []modules.SiacoinOutputDiff{
+				{
+					Direction:     modules.DiffRevert,
+					SiacoinOutput: conflict.SiacoinOutputs[0],
+					ID:            conflict.SiacoinOutputID(0),
+				},
+				{
+					Direction:     modules.DiffApply,
+					SiacoinOutput: funding.SiacoinOutputs[0],
+					ID:            funding.SiacoinOutputID(0),
+				},
+			},
+		},
+	})
+	checkConflicts(nil)
+}
+
+func TestEventHubConflictIndex(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	addr := info.UnlockHash()
+	funding := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(funding)
+
+	// add three generations of transactions to Limbo, children first
+	input := types.SiacoinInput{
+		ParentID:         funding.SiacoinOutputID(0),
+		UnlockConditions: info.UnlockConditions,
+	}
+	txns := []types.Transaction{{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}}
+	for i := 0; i < 2; i++ {
+		parent := txns[len(txns)-1]
+		txns = append(txns, types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				ParentID:         parent.SiacoinOutputID(0),
+				UnlockConditions: info.UnlockConditions,
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+		})
+	}
+	for i := len(txns) - 1; i >= 0; i-- {
+		w.AddToLimbo(txns[i])
+	}
+
+	// confirm a conflicting transaction before the hub is created; the hub
+	// should index it from the wallet's history
+	conflict := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: types.UnlockHash{1}, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(conflict)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limbo := w.LimboTransactions()
+	exp := limboConflicts(w, limbo)
+	if conflicts := hub.limboConflicts(limbo); !reflect.DeepEqual(conflicts, exp) {
+		t.Fatalf("hub conflicts %v do not match wallet conflicts %v", conflicts, exp)
+	} else if len(conflicts) != len(txns) {
+		t.Fatalf("expected %v conflicts, got %v", len(txns), len(conflicts))
+	}
+	for _, txn := range txns {
+		if hub.limboConflicts(limbo)[txn.ID()] != conflict.ID() {
+			t.Fatal("wrong conflict for", txn.ID())
+		}
+	}
+
+	// reverting the conflicting transaction should update the index
+	hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)).ProcessConsensusChange(modules.ConsensusChange{
+		RevertedBlocks: []types.Block{{
+			Transactions: []types.Transaction{conflict},
+		}},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{
+			SiacoinOutputDiffs: []modules.SiacoinOutputDiff{
+				{
+					Direction:     modules.DiffRevert,
+					SiacoinOutput: conflict.SiacoinOutputs[0],
+					ID:            conflict.SiacoinOutputID(0),
+				},
+				{
+					Direction:     modules.DiffApply,
+					SiacoinOutput: funding.SiacoinOutputs[0],
+					ID:            funding.SiacoinOutputID(0),
+				},
+			},
+		},
+	})
+	if conflicts := hub.limboConflicts(w.LimboTransactions()); len(conflicts) != 0 {
+		t.Fatal("expected no conflicts after revert, got", conflicts)
+	}
+}
+
+func TestServerLimboConflictBalance(t *testing.T) {
+	// conflicts should be detected without an EventHub
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	addr := info.UnlockHash()
+	funding := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{UnlockHash: addr, Value: types.SiacoinPrecision},
+			{UnlockHash: addr, Value: types.SiacoinPrecision},
+		},
+	}
+	cs.sendTxn(funding)
+
+	// add a payment with change to Limbo
+	input := types.SiacoinInput{
+		ParentID:         funding.SiacoinOutputID(0),
+		UnlockConditions: info.UnlockConditions,
+	}
+	payment := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{UnlockHash: types.UnlockHash{1}, Value: types.SiacoinPrecision.Div64(2)},
+			{UnlockHash: addr, Value: types.SiacoinPrecision.Div64(2)},
+		},
+	}
+	if err := client.AddToLimbo(payment); err != nil {
+		t.Fatal(err)
+	}
+	if bal, err := client.Balance(true); err != nil {
+		t.Fatal(err)
+	} else if exp := types.SiacoinPrecision.Mul64(3).Div64(2); !bal.Equals(exp) {
+		t.Fatalf("expected limbo balance of %v, got %v", exp, bal)
+	}
+
+	// confirm a different transaction spending the same input; the payment's
+	// change should no longer be counted
+	conflict := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: types.UnlockHash{2}, Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(conflict)
+	if bal, err := client.Balance(true); err != nil {
+		t.Fatal(err)
+	} else if !bal.Equals(types.SiacoinPrecision) {
+		t.Fatalf("expected limbo balance of %v, got %v", types.SiacoinPrecision, bal)
+	}
+	if utxos, err := client.UnspentOutputs(true); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 1 || utxos[0].ID != funding.SiacoinOutputID(1) {
+		t.Fatal("conflicting transaction's outputs should be excluded:", utxos)
+	}
+
+	// a transaction added to Limbo after its input was spent should also be
+	// flagged
+	late := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input},
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+	}
+	if err := client.AddToLimbo(late); err != nil {
+		t.Fatal(err)
+	}
+	var limbo []struct {
+		ID            types.TransactionID  `json:"id"`
+		ConflictingID *types.TransactionID `json:"conflictingID"`
+	}
+	if err := client.get("/limbo", &limbo); err != nil {
+		t.Fatal(err)
+	} else if len(limbo) != 2 {
+		t.Fatal("expected 2 limbo transactions, got", len(limbo))
+	}
+	for _, txn := range limbo {
+		if txn.ConflictingID == nil || *txn.ConflictingID != conflict.ID() {
+			t.Fatal("expected conflict for", txn.ID)
+		}
+	}
+	if bal, err := client.Balance(true); err != nil {
+		t.Fatal(err)
+	} else if !bal.Equals(types.SiacoinPrecision) {
+		t.Fatalf("expected limbo balance of %v, got %v", types.SiacoinPrecision, bal)
+	}
+}
+
+func TestServerMemos(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	var txids []types.TransactionID
+	for i := 0; i < 3; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.NewCurrency64(uint64(i + 1))}},
+		}
+		cs.sendTxn(txn)
+		txids = append(txids, txn.ID())
+	}
+	// memos stored before the MemoStore was created should be indexed
+	w.SetMemo(txids[2], []byte("legacy"))
+
+	path := filepath.Join(dir, "memos.json")
+	ms, err := NewMemoStore(w, MemoOptions{Path: path, MaxSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, stop := runServer(NewServer(w, stubTpool{}, Memos(ms)))
+	defer stop()
+
+	// text memos should be stored with a detected content type
+	if err := client.SetMemo(txids[0], []byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if memo, err := client.Memo(txids[0]); err != nil {
+		t.Fatal(err)
+	} else if string(memo) != "foo" {
+		t.Fatalf("wrong memo: %q", memo)
+	}
+	if m, err := client.TypedMemo(txids[0]); err != nil {
+		t.Fatal(err)
+	} else if m.ContentType != "text/plain; charset=utf-8" {
+		t.Fatal("wrong content type:", m.ContentType)
+	}
+
+	// binary memos should be stored with the supplied content type
+	bin := []byte{0xFF, 0x00, 0xFE}
+	if err := client.SetTypedMemo(txids[1], "application/octet-stream", bin); err != nil {
+		t.Fatal(err)
+	} else if m, err := client.TypedMemo(txids[1]); err != nil {
+		t.Fatal(err)
+	} else if m.ContentType != "application/octet-stream" || !bytes.Equal(m.Data, bin) {
+		t.Fatal("wrong memo:", m.ContentType, m.Data)
+	}
+	if err := client.SetTypedMemo(txids[1], "not a media type", bin); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("expected invalid content type to be rejected, got", err)
+	}
+
+	// memos exceeding the size limit should be rejected
+	if err := client.SetMemo(txids[0], make([]byte, 17)); !errors.Is(err, ErrMemoTooLarge) {
+		t.Fatal("expected memo_too_large, got", err)
+	}
+
+	// memos should appear in transaction responses
+	if txn, err := client.Transaction(txids[0]); err != nil {
+		t.Fatal(err)
+	} else if txn.Memo != "foo" || txn.MemoType != "text/plain; charset=utf-8" || txn.MemoData != nil {
+		t.Fatal("wrong memo in transaction:", txn.Memo, txn.MemoType, txn.MemoData)
+	}
+	if txn, err := client.Transaction(txids[1]); err != nil {
+		t.Fatal(err)
+	} else if txn.Memo != "" || !bytes.Equal(txn.MemoData, bin) {
+		t.Fatal("wrong memo in transaction:", txn.Memo, txn.MemoData)
+	}
+
+	// all memos should be listed, most recent first
+	memos, err := client.Memos()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(memos) != 3 {
+		t.Fatal("expected 3 memos, got", len(memos))
+	} else if memos[0].TransactionID != txids[1] || !bytes.Equal(memos[0].Data, bin) {
+		t.Fatal("wrong first memo:", memos[0])
+	} else if memos[2].TransactionID != txids[2] || string(memos[2].Data) != "legacy" {
+		t.Fatal("wrong legacy memo:", memos[2])
+	}
+	page, next, err := client.MemosPage("", 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page) != 2 || next == "" {
+		t.Fatal("wrong page:", len(page), next)
+	} else if page, _, err = client.MemosPage(next, 2); err != nil {
+		t.Fatal(err)
+	} else if len(page) != 1 || page[0].TransactionID != txids[2] {
+		t.Fatal("wrong second page")
+	}
+
+	// deleted memos should no longer be found
+	if err := client.DeleteMemo(txids[0]); err != nil {
+		t.Fatal(err)
+	} else if memo, err := client.Memo(txids[0]); err != nil || memo != nil {
+		t.Fatal("expected no memo, got", memo, err)
+	} else if _, err := client.TypedMemo(txids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected not_found, got", err)
+	}
+
+	// content types should persist
+	ms, err = NewMemoStore(w, MemoOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	} else if memos := ms.Memos(); len(memos) != 2 {
+		t.Fatal("expected 2 memos, got", len(memos))
+	} else if m, _ := ms.Memo(txids[1]); m.ContentType != "application/octet-stream" {
+		t.Fatal("content type was not persisted")
+	}
+}
+
+func TestServerDefaultMemoStore(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	legacy := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.NewCurrency64(1)}},
+	}
+	cs.sendTxn(legacy)
+	w.SetMemo(legacy.ID(), []byte("legacy"))
+
+	srv := httptest.NewServer(NewServer(w, stubTpool{}, Events(hub)))
+	defer srv.Close()
+	client := NewClient(srv.URL)
+
+	// events should report the same memo type as /transactions
+	payment := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.NewCurrency64(2)}},
+	}
+	if err := client.SetTypedMemo(payment.ID(), "application/json", []byte(`{"invoice":42}`)); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := hub.Subscribe(EventTransactionConfirmed)
+	defer unsubscribe()
+	cs.sendTxn(payment)
+	select {
+	case e := <-events:
+		if e.Transaction.MemoType != "application/json" {
+			t.Fatal("wrong memo type in event:", e.Transaction.MemoType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no transactionConfirmed event")
+	}
+
+	// memos for unknown transactions should survive a restart, along with
+	// legacy memos
+	unknown := types.TransactionID{1}
+	if err := client.SetMemo(unknown, []byte("unknown")); err != nil {
+		t.Fatal(err)
+	}
+	srv2 := httptest.NewServer(NewServer(w, stubTpool{}))
+	defer srv2.Close()
+	memos, err := NewClient(srv2.URL).Memos()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(memos) != 3 {
+		t.Fatal("expected 3 memos, got", len(memos))
+	} else if memos[0].TransactionID != unknown || memos[1].ContentType != "application/json" || memos[2].TransactionID != legacy.ID() {
+		t.Fatal("wrong memos:", memos)
+	}
+}
+
+func TestServerMemoSearch(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	memos := []string{"Invoice ABC-123", "refund for abc-123", "Invoice XYZ-9"}
+	var txids []types.TransactionID
+	for i := range memos {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.NewCurrency64(uint64(i + 1))}},
+		}
+		cs.sendTxn(txn)
+		txids = append(txids, txn.ID())
+	}
+	for i, memo := range memos {
+		if err := client.SetMemo(txids[i], []byte(memo)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// binary memos should never match
+	if err := client.SetTypedMemo(types.TransactionID{1}, "application/octet-stream", []byte("abc-123")); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(q MemoQuery) []types.TransactionID {
+		t.Helper()
+		q.Limit = -1
+		txns, _, err := client.SearchMemos(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]types.TransactionID, len(txns))
+		for i := range txns {
+			ids[i] = txns[i].Transaction.ID()
+		}
+		return ids
+	}
+	tests := []struct {
+		q   MemoQuery
+		exp []types.TransactionID
+	}{
+		{MemoQuery{Text: "ABC-123"}, []types.TransactionID{txids[1], txids[0]}},
+		{MemoQuery{Text: "invoice", Prefix: true}, []types.TransactionID{txids[2], txids[0]}},
+		{MemoQuery{Text: "abc", Prefix: true}, []types.TransactionID{}},
+		{MemoQuery{Text: "xyz-9"}, []types.TransactionID{txids[2]}},
+	}
+	for _, test := range tests {
+		if ids := search(test.q); !reflect.DeepEqual(ids, test.exp) {
+			t.Errorf("search %+v: expected %v, got %v", test.q, test.exp, ids)
+		}
+	}
+
+	// later changes should be reflected in the results, and Limbo
+	// transactions should be found
+	limboTxn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: types.SiacoinOutputID{2}}},
+	}
+	w.AddToLimbo(limboTxn)
+	if err := client.SetMemo(limboTxn.ID(), []byte("pending ABC-123")); err != nil {
+		t.Fatal(err)
+	} else if err := client.DeleteMemo(txids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search(MemoQuery{Text: "abc-123"}); len(ids) != 2 || ids[0] != limboTxn.ID() || ids[1] != txids[0] {
+		t.Fatal("wrong results after update:", ids)
+	} else if txns, _, err := client.SearchMemos(MemoQuery{Text: "pending"}); err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 || txns[0].Status != StatusLimbo {
+		t.Fatal("wrong Limbo result:", txns)
+	}
+	w.RemoveFromLimbo(limboTxn.ID())
+	if err := client.DeleteMemo(limboTxn.ID()); err != nil {
+		t.Fatal(err)
+	} else if err := client.SetMemo(txids[1], []byte(memos[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	// results should include transaction metadata and support pagination
+	txns, next, err := client.SearchMemos(MemoQuery{Text: "123", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 || next == "" || txns[0].Memo != memos[1] || txns[0].Status != StatusConfirmed {
+		t.Fatal("wrong first page:", txns, next)
+	}
+	txns, next, err = client.SearchMemos(MemoQuery{Text: "123", Limit: 1, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 || next != "" || txns[0].Memo != memos[0] {
+		t.Fatal("wrong second page:", txns, next)
+	}
+
+	if _, _, err := client.SearchMemos(MemoQuery{}); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("expected empty query to be rejected, got", err)
+	}
+}
+
+func compactJSON(js []byte) string {
+	var buf bytes.Buffer
+	json.Compact(&buf, js)
+	return buf.String()
+}
+
+func TestServerAddressMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := wallet.New(wallet.NewEphemeralStore())
+	path := filepath.Join(dir, "labels.json")
+	ls, err := NewLabelStore(LabelOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, stop := runServer(NewServer(w, stubTpool{}, Labels(ls)))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	var infos []wallet.SeedAddressInfo
+	for i := uint64(0); i < 3; i++ {
+		infos = append(infos, wallet.SeedAddressInfo{
+			UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(i)),
+			KeyIndex:         i,
+		})
+	}
+	addr := func(i int) types.UnlockHash { return infos[i].UnlockHash() }
+
+	// add addresses with and without metadata
+	md := AddressMetadata{
+		Label:    "Alice",
+		Tags:     []string{"customer", "deposit", "customer"},
+		Metadata: []byte(`{"customerID":42}`),
+	}
+	if err := client.AddAddressWithMetadata(infos[0], md); err != nil {
+		t.Fatal(err)
+	} else if err := client.AddAddress(infos[1]); err != nil {
+		t.Fatal(err)
+	} else if err := client.AddAddressWithMetadata(infos[2], AddressMetadata{Tags: []string{"change"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata should be returned, with tags deduplicated
+	if got, err := client.AddressMetadata(addr(0)); err != nil {
+		t.Fatal(err)
+	} else if got.Label != "Alice" || !reflect.DeepEqual(got.Tags, []string{"customer", "deposit"}) || compactJSON(got.Metadata) != `{"customerID":42}` {
+		t.Fatalf("wrong metadata: %+v", got)
+	}
+	if got, err := client.AddressMetadata(addr(1)); err != nil {
+		t.Fatal(err)
+	} else if !got.IsEmpty() {
+		t.Fatalf("expected no metadata, got %+v", got)
+	}
+	if info, err := client.AddressInfo(addr(0)); err != nil {
+		t.Fatal(err)
+	} else if info.UnlockHash() != addr(0) || info.KeyIndex != 0 {
+		t.Fatal("address info is inaccurate")
+	}
+
+	// address lists should be filterable by tag
+	if addrs, err := client.AddressesByTag("customer"); err != nil {
+		t.Fatal(err)
+	} else if len(addrs) != 1 || addrs[0] != addr(0) {
+		t.Fatal("wrong addresses:", addrs)
+	}
+	if addrs, err := client.AddressesByTag("customer", "change"); err != nil {
+		t.Fatal(err)
+	} else if len(addrs) != 0 {
+		t.Fatal("expected no addresses, got", addrs)
+	}
+	if resp, err := client.AddressesWithMetadata(); err != nil {
+		t.Fatal(err)
+	} else if len(resp) != 3 {
+		t.Fatal("expected 3 addresses, got", len(resp))
+	}
+	if resp, err := client.AddressesWithMetadata("change"); err != nil {
+		t.Fatal(err)
+	} else if len(resp) != 1 || resp[0].Address != addr(2) || resp[0].UnlockHash() != addr(2) || resp[0].Tags[0] != "change" {
+		t.Fatalf("wrong addresses: %+v", resp)
+	}
+
+	// metadata can be replaced, but only for known addresses
+	if err := client.SetAddressMetadata(addr(1), AddressMetadata{Label: "Bob", Tags: []string{"customer"}}); err != nil {
+		t.Fatal(err)
+	} else if addrs, err := client.AddressesByTag("customer"); err != nil {
+		t.Fatal(err)
+	} else if len(addrs) != 2 {
+		t.Fatal("expected 2 addresses, got", addrs)
+	}
+	if err := client.SetAddressMetadata(types.UnlockHash{1}, AddressMetadata{Label: "Eve"}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected not_found, got", err)
+	} else if err := client.SetAddressMetadata(addr(1), AddressMetadata{Tags: []string{" "}}); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("expected empty tag to be rejected, got", err)
+	}
+
+	// removing an address should remove its metadata
+	if err := client.RemoveAddress(addr(2)); err != nil {
+		t.Fatal(err)
+	} else if !ls.Metadata(addr(2)).IsEmpty() {
+		t.Fatal("metadata was not removed")
+	}
+
+	// metadata should persist
+	ls, err = NewLabelStore(LabelOptions{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	} else if md := ls.Metadata(addr(1)); md.Label != "Bob" {
+		t.Fatalf("metadata was not persisted: %+v", md)
+	} else if md := ls.Metadata(addr(0)); compactJSON(md.Metadata) != `{"customerID":42}` {
+		t.Fatalf("metadata was not persisted: %+v", md)
+	}
+}
+
+func TestServerFundTransaction(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	var addrs []types.UnlockHash
+	for i := uint64(0); i < 3; i++ {
+		info := wallet.SeedAddressInfo{
+			UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(i)),
+			KeyIndex:         i,
+		}
+		w.AddAddress(info)
+		addrs = append(addrs, info.UnlockHash())
+	}
+	for i, addr := range addrs {
+		cs.sendTxn(types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(uint64(i + 1))}},
+		})
+	}
+
+	dest := types.UnlockHash{1, 2, 3}
+	feePerByte := types.NewCurrency64(100)
+	resp, err := client.FundTransaction(RequestTxnFund{
+		Outputs:       []types.SiacoinOutput{{UnlockHash: dest, Value: types.SiacoinPrecision.Mul64(5).Div64(2)}},
+		FeePerByte:    feePerByte,
+		ChangeAddress: &addrs[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	if len(txn.SiacoinInputs) == 0 || len(resp.ToSign) != len(txn.SiacoinInputs) {
+		t.Fatal("wrong number of inputs or signatures:", len(txn.SiacoinInputs), len(resp.ToSign))
+	}
+	var in, out types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		o, _ := cs.utxos[sci.ParentID]
+		in = in.Add(o.Value)
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		out = out.Add(sco.Value)
+	}
+	if !in.Equals(out.Add(resp.Fee)) {
+		t.Fatal("inputs do not equal outputs plus fee")
+	} else if !txn.SiacoinOutputs[1].Value.Equals(resp.Change) || txn.SiacoinOutputs[1].UnlockHash != addrs[0] {
+		t.Fatal("wrong change output")
+	}
+
+	// sign the transaction; it should then be valid, and its fee should
+	// cover its size
+	for _, sig := range resp.ToSign {
+		txn.TransactionSignatures[sig.SigIndex].Signature = ed25519hash.Sign(seed.SecretKey(sig.KeyIndex), sig.SigHash)
+	}
+	if err := txn.StandaloneValid(types.FoundationHardforkHeight + 1); err != nil {
+		t.Fatal(err)
+	} else if resp.Fee.Cmp(feePerByte.Mul64(uint64(txn.MarshalSiaSize()))) < 0 {
+		t.Fatal("insufficient fee")
+	}
+
+	// outputs spent by Limbo transactions should not be used
+	if err := client.AddToLimbo(txn); err != nil {
+		t.Fatal(err)
+	}
+	resp2, err := client.FundTransaction(RequestTxnFund{
+		Outputs: []types.SiacoinOutput{{UnlockHash: dest, Value: types.NewCurrency64(1)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sci := range resp2.Transaction.SiacoinInputs {
+		for _, spent := range txn.SiacoinInputs {
+			if sci.ParentID == spent.ParentID {
+				t.Fatal("funded transaction spends a Limbo output")
+			}
+		}
+	}
+
+	if _, err := client.FundTransaction(RequestTxnFund{
+		Outputs: []types.SiacoinOutput{{UnlockHash: dest, Value: types.SiacoinPrecision.Mul64(100)}},
+	}); err == nil {
+		t.Fatal("expected insufficient funds error")
+	}
+}
+
+func TestServerConsolidate(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	feePerByte := types.NewCurrency64(100)
+	client, stop := runServer(NewServer(w, feeTpool{min: feePerByte, max: feePerByte.Mul64(3)}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+		KeyIndex:         0,
+	}
+	w.AddAddress(info)
+	addr := info.UnlockHash()
+	// ten small outputs, one large output, and one dust output
+	var outputs []types.SiacoinOutput
+	for i := uint64(1); i <= 10; i++ {
+		outputs = append(outputs, types.SiacoinOutput{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(i)})
+	}
+	outputs = append(outputs, types.SiacoinOutput{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(100)})
+	outputs = append(outputs, types.SiacoinOutput{UnlockHash: addr, Value: types.NewCurrency64(1)})
+	cs.sendTxn(types.Transaction{SiacoinOutputs: outputs})
+
+	// limit each transaction to four inputs
+	maxSize := estimateSize(types.Transaction{}, 4)
+	dest := types.UnlockHash{1, 2, 3}
+	txns, err := client.ConsolidateOutputs(RequestTxnConsolidate{
+		Address:  dest,
+		MaxValue: types.SiacoinPrecision.Mul64(10),
+		MaxSize:  maxSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 3 {
+		t.Fatal("expected 3 transactions, got", len(txns))
+	}
+	seen := make(map[types.SiacoinOutputID]bool)
+	for i, resp := range txns {
+		txn := resp.Transaction
+		if exp := []int{4, 4, 2}[i]; len(txn.SiacoinInputs) != exp {
+			t.Fatalf("expected %v inputs, got %v", exp, len(txn.SiacoinInputs))
+		} else if len(txn.SiacoinOutputs) != 1 || txn.SiacoinOutputs[0].UnlockHash != dest {
+			t.Fatal("expected a single output to destination")
+		}
+		var in types.Currency
+		for _, sci := range txn.SiacoinInputs {
+			o := cs.utxos[sci.ParentID]
+			if o.Value.Cmp(types.SiacoinPrecision.Mul64(10)) > 0 || o.Value.Cmp(types.SiacoinPrecision) < 0 {
+				t.Fatal("consolidated an output outside the requested range:", o.Value)
+			} else if seen[sci.ParentID] {
+				t.Fatal("output consolidated twice")
+			}
+			seen[sci.ParentID] = true
+			in = in.Add(o.Value)
+		}
+		if !in.Equals(txn.SiacoinOutputs[0].Value.Add(resp.Fee)) {
+			t.Fatal("inputs do not equal outputs plus fee")
+		}
+		for _, sig := range resp.ToSign {
+			txn.TransactionSignatures[sig.SigIndex].Signature = ed25519hash.Sign(seed.SecretKey(sig.KeyIndex), sig.SigHash)
+		}
+		if err := txn.StandaloneValid(types.FoundationHardforkHeight + 1); err != nil {
+			t.Fatal(err)
+		} else if size := uint64(txn.MarshalSiaSize()); size > maxSize {
+			t.Fatal("transaction exceeds maximum size:", size)
+		} else if resp.Fee.Cmp(feePerByte.Mul64(size)) < 0 {
+			t.Fatal("insufficient fee")
+		}
+	}
+
+	// fee rates above the tpool maximum should be rejected
+	if _, err := client.ConsolidateOutputs(RequestTxnConsolidate{
+		Address:    dest,
+		FeePerByte: feePerByte.Mul64(4),
+	}); err == nil {
+		t.Fatal("expected fee rate to be rejected")
+	}
+}
+
+func TestServerSweep(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	feePerByte := types.NewCurrency64(100)
+	client, stop := runServer(NewServer(w, feeTpool{min: feePerByte, max: feePerByte}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	var addrs []types.UnlockHash
+	for i := uint64(0); i < 3; i++ {
+		info := wallet.SeedAddressInfo{
+			UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(i)),
+			KeyIndex:         i,
+		}
+		w.AddAddress(info)
+		addrs = append(addrs, info.UnlockHash())
+	}
+	var outputs []types.SiacoinOutput
+	for i, addr := range addrs {
+		outputs = append(outputs, types.SiacoinOutput{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(uint64(i + 1))})
+	}
+	// dust should be ignored
+	outputs = append(outputs, types.SiacoinOutput{UnlockHash: addrs[0], Value: types.NewCurrency64(1)})
+	cs.sendTxn(types.Transaction{SiacoinOutputs: outputs})
+
+	dest := types.UnlockHash{1, 2, 3}
+	resp, err := client.SweepTransaction(RequestTxnSweep{Address: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := resp.Transaction
+	if len(txn.SiacoinInputs) != 3 || len(resp.ToSign) != 3 {
+		t.Fatal("expected 3 inputs, got", len(txn.SiacoinInputs))
+	} else if len(txn.SiacoinOutputs) != 1 || txn.SiacoinOutputs[0].UnlockHash != dest {
+		t.Fatal("expected a single output to destination")
+	} else if !txn.SiacoinOutputs[0].Value.Add(resp.Fee).Equals(types.SiacoinPrecision.Mul64(6)) {
+		t.Fatal("output plus fee should equal wallet balance")
+	}
+	for _, sig := range resp.ToSign {
+		txn.TransactionSignatures[sig.SigIndex].Signature = ed25519hash.Sign(seed.SecretKey(sig.KeyIndex), sig.SigHash)
+	}
+	if err := txn.StandaloneValid(types.FoundationHardforkHeight + 1); err != nil {
+		t.Fatal(err)
+	} else if resp.Fee.Cmp(feePerByte.Mul64(uint64(txn.MarshalSiaSize()))) < 0 {
+		t.Fatal("insufficient fee")
+	}
+
+	// filter by address and coin control
+	var excluded types.SiacoinOutputID
+	for id, o := range cs.utxos {
+		if o.UnlockHash == addrs[1] {
+			excluded = id
+		}
+	}
+	resp, err = client.SweepTransaction(RequestTxnSweep{
+		Address:     dest,
+		Addresses:   addrs[1:],
+		CoinControl: CoinControl{Exclude: []types.SiacoinOutputID{excluded}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(resp.Transaction.SiacoinInputs) != 1 {
+		t.Fatal("expected 1 input, got", len(resp.Transaction.SiacoinInputs))
+	} else if o := cs.utxos[resp.Transaction.SiacoinInputs[0].ParentID]; o.UnlockHash != addrs[2] {
+		t.Fatal("swept wrong output")
+	}
+
+	// nothing to sweep
+	if _, err := client.SweepTransaction(RequestTxnSweep{
+		Address:   dest,
+		Addresses: []types.UnlockHash{dest},
+	}); err == nil {
+		t.Fatal("expected insufficient funds error")
+	}
+}
+
+func TestServerFees(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	client, stop := runServer(NewServer(w, feeTpool{min: types.NewCurrency64(100), max: types.NewCurrency64(300)}))
+	defer stop()
+
+	if fee, err := client.RecommendedFee(); err != nil {
+		t.Fatal(err)
+	} else if !fee.Equals64(100) {
+		t.Fatal("wrong recommended fee:", fee)
+	}
+	exp := ResponseFee{
+		Min:     types.NewCurrency64(100),
+		Max:     types.NewCurrency64(300),
+		Economy: types.NewCurrency64(100),
+		Normal:  types.NewCurrency64(200),
+		Urgent:  types.NewCurrency64(300),
+	}
+	if fees, err := client.Fees(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(fees, exp) {
+		t.Fatal("wrong fees:", fees)
+	}
+	var fee types.Currency
+	if err := client.get("/fee?tier=urgent", &fee); err != nil {
+		t.Fatal(err)
+	} else if !fee.Equals(exp.Urgent) {
+		t.Fatal("wrong urgent fee:", fee)
+	} else if err := client.get("/fee?tier=whenever", &fee); err == nil {
+		t.Fatal("expected invalid tier to be rejected")
+	}
+	if min, max, err := client.ProtoTransactionPool().FeeEstimate(); err != nil {
+		t.Fatal(err)
+	} else if !min.Equals(exp.Min) || !max.Equals(exp.Max) {
+		t.Fatal("wrong fee estimate:", min, max)
+	}
+
+	// fees should account for missing signatures
+	seed := wallet.NewSeed()
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+		}},
+		SiacoinOutputs:        []types.SiacoinOutput{{Value: types.SiacoinPrecision}},
+		MinerFees:             []types.Currency{types.NewCurrency64(1000)},
+		TransactionSignatures: []types.TransactionSignature{wallet.StandardTransactionSignature(crypto.Hash{})},
+	}
+	resp, err := client.TransactionFee(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.TransactionSignatures[0].Signature = make([]byte, crypto.SignatureSize)
+	size := uint64(txn.MarshalSiaSize())
+	if resp.Size != size {
+		t.Fatalf("expected size %v, got %v", size, resp.Size)
+	} else if !resp.MinerFees.Equals64(1000) {
+		t.Fatal("wrong miner fees:", resp.MinerFees)
+	} else if !resp.Economy.Equals64(100*size) || !resp.Normal.Equals64(200*size) || !resp.Urgent.Equals64(300*size) {
+		t.Fatal("wrong tier fees:", resp.Economy, resp.Normal, resp.Urgent)
+	}
+
+	// fees should account for a miner fee that has not yet been added
+	txn.MinerFees = nil
+	resp, err = client.TransactionFee(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.MinerFees = []types.Currency{maxCurrency}
+	size = uint64(txn.MarshalSiaSize())
+	if resp.Size != size {
+		t.Fatalf("expected size %v, got %v", size, resp.Size)
+	} else if !resp.MinerFees.IsZero() {
+		t.Fatal("wrong miner fees:", resp.MinerFees)
+	}
+}
+
+func TestServerValidate(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, feeTpool{min: types.NewCurrency64(10), max: types.NewCurrency64(30)}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+		KeyIndex:         0,
+	}
+	w.AddAddress(info)
+	addr := info.UnlockHash()
+	cs.sendTxn(types.Transaction{})
+	funding := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(10)}},
+	}
+	cs.sendTxn(funding)
+
+	sign := func(txn types.Transaction, keyIndex uint64) types.Transaction {
+		txn.TransactionSignatures = nil
+		for _, sci := range txn.SiacoinInputs {
+			txn.TransactionSignatures = append(txn.TransactionSignatures, wallet.StandardTransactionSignature(crypto.Hash(sci.ParentID)))
+		}
+		for i := range txn.TransactionSignatures {
+			sigHash := txn.SigHash(i, types.FoundationHardforkHeight+1)
+			txn.TransactionSignatures[i].Signature = ed25519hash.Sign(seed.SecretKey(keyIndex), sigHash)
+		}
+		return txn
+	}
+	spend := func(parentID types.SiacoinOutputID, value, fee types.Currency) types.Transaction {
+		return types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				ParentID:         parentID,
+				UnlockConditions: info.UnlockConditions,
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: value.Sub(fee)}},
+			MinerFees:      []types.Currency{fee},
+		}
+	}
+	validate := func(txnSet ...types.Transaction) ResponseValidate {
+		t.Helper()
+		resp, err := client.ValidateTransactionSet(txnSet)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(resp.Transactions) != len(txnSet) {
+			t.Fatal("wrong number of transactions in response")
+		}
+		return resp
+	}
+	codes := func(resp ResponseValidate) (errs, warns []string) {
+		ds := append([]Diagnostic(nil), resp.Diagnostics...)
+		for _, td := range resp.Transactions {
+			ds = append(ds, td.Diagnostics...)
+		}
+		for _, d := range ds {
+			if d.Severity == SeverityError {
+				errs = append(errs, d.Code)
+			} else {
+				warns = append(warns, d.Code)
+			}
+		}
+		return
+	}
+	sc := types.SiacoinPrecision.Mul64
+	fundingID := funding.SiacoinOutputID(0)
+
+	// a valid set, including a child spending an output created in the set
+	parent := sign(spend(fundingID, sc(10), sc(1)), 0)
+	child := sign(spend(parent.SiacoinOutputID(0), sc(9), sc(1)), 0)
+	if resp := validate(parent, child); !resp.Valid {
+		t.Fatal("expected valid set:", resp)
+	} else if errs, warns := codes(resp); len(errs) != 0 || len(warns) != 0 {
+		t.Fatal("expected no diagnostics:", errs, warns)
+	} else if !resp.Fee.Equals(sc(2)) || resp.Transactions[0].Size != uint64(parent.MarshalSiaSize()) {
+		t.Fatal("wrong fee or size")
+	}
+
+	tests := []struct {
+		desc  string
+		set   []types.Transaction
+		errs  []string
+		warns []string
+	}{
+		{"unsigned", []types.Transaction{spend(fundingID, sc(10), sc(1))}, []string{"invalid_signature"}, nil},
+		{"value mismatch", []types.Transaction{sign(spend(fundingID, sc(11), sc(1)), 0)}, []string{"value_mismatch"}, nil},
+		{"double spend", []types.Transaction{parent, sign(spend(fundingID, sc(10), sc(2)), 0)}, []string{"double_spend"}, nil},
+		{"missing input", []types.Transaction{sign(spend(types.SiacoinOutputID{1}, sc(10), sc(1)), 0)}, []string{"missing_input"}, nil},
+		{"low fee", []types.Transaction{sign(spend(fundingID, sc(10), types.NewCurrency64(1)), 0)}, []string{"insufficient_fee"}, []string{"low_fee"}},
+	}
+	for _, test := range tests {
+		resp := validate(test.set...)
+		errs, warns := codes(resp)
+		if resp.Valid || !reflect.DeepEqual(errs, test.errs) || !reflect.DeepEqual(warns, test.warns) {
+			t.Errorf("%v: expected %v/%v, got %v/%v (valid: %v)", test.desc, test.errs, test.warns, errs, warns, resp.Valid)
+		}
+	}
+
+	// inputs not owned by the wallet cannot be checked
+	foreign := spend(types.SiacoinOutputID{1}, sc(10), sc(1))
+	foreign.SiacoinInputs[0].UnlockConditions = wallet.StandardUnlockConditions(seed.PublicKey(1))
+	foreign = sign(foreign, 1)
+	if resp := validate(foreign); !resp.Valid {
+		t.Fatal("expected valid set:", resp)
+	} else if _, warns := codes(resp); !reflect.DeepEqual(warns, []string{"unknown_input"}) {
+		t.Fatal("expected unknown_input warning, got", warns)
+	}
+
+	// spending an output already spent in Limbo is a conflict, but
+	// revalidating the Limbo transaction itself is not
+	if err := client.AddToLimbo(parent); err != nil {
+		t.Fatal(err)
+	}
+	if resp := validate(parent, child); !resp.Valid {
+		t.Fatal("expected valid set:", resp)
+	}
+	if resp := validate(sign(spend(fundingID, sc(10), sc(2)), 0)); resp.Valid {
+		t.Fatal("expected invalid set")
+	} else if errs, _ := codes(resp); !reflect.DeepEqual(errs, []string{"limbo_conflict"}) {
+		t.Fatal("expected limbo_conflict, got", errs)
+	}
+	if resp := validate(child); !resp.Valid {
+		t.Fatal("expected child of Limbo transaction to be valid:", resp)
+	}
+
+	if _, err := client.ValidateTransactionSet(nil); err == nil {
+		t.Fatal("expected empty set to be rejected")
+	}
+}
+
+func TestServerReservations(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+	for i := 0; i < 3; i++ {
+		cs.sendTxn(types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision}},
+			ArbitraryData:  [][]byte{{byte(i)}},
+		})
+	}
+	utxos, err := client.UnspentOutputs(false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 3 {
+		t.Fatal("expected 3 outputs, got", len(utxos))
+	}
+
+	// reserve two outputs
+	reserved := []types.SiacoinOutputID{utxos[0].ID, utxos[1].ID}
+	res, err := client.ReserveOutputs(reserved, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(res) != 2 || res[0].Token == "" || res[0].Token != res[1].Token {
+		t.Fatal("expected a shared reservation token:", res)
+	}
+	token := res[0].Token
+	if res, err := client.Reservations(); err != nil {
+		t.Fatal(err)
+	} else if len(res) != 2 {
+		t.Fatal("expected 2 reservations, got", len(res))
+	} else if res[0].Token != "" {
+		t.Fatal("listed reservations should not reveal their tokens")
+	}
+	if unreserved, err := client.UnreservedOutputs(false); err != nil {
+		t.Fatal(err)
+	} else if len(unreserved) != 1 || unreserved[0].ID != utxos[2].ID {
+		t.Fatal("reserved outputs should be excluded:", unreserved)
+	}
+	if bal, err := client.UnreservedBalance(false); err != nil {
+		t.Fatal(err)
+	} else if !bal.Equals(types.SiacoinPrecision) {
+		t.Fatal("wrong unreserved balance:", bal)
+	}
+	// reservations are all-or-nothing
+	if _, err := client.ReserveOutputs([]types.SiacoinOutputID{utxos[2].ID, utxos[0].ID}, time.Minute); err == nil {
+		t.Fatal("expected conflicting reservation to be rejected")
+	} else if res, _ := client.Reservations(); len(res) != 2 {
+		t.Fatal("conflicting reservation should not reserve any outputs")
+	}
+	if _, err := client.ReserveOutputs([]types.SiacoinOutputID{{1}}, time.Minute); err == nil {
+		t.Fatal("expected unknown output to be rejected")
+	}
+	if _, err := client.ReserveOutputs([]types.SiacoinOutputID{utxos[2].ID}, MaxReservationDuration+time.Second); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("expected excessive duration to be rejected, got", err)
+	} else if _, err := client.ReserveOutputs([]types.SiacoinOutputID{utxos[2].ID}, time.Duration(1<<62)); !errors.Is(err, ErrBadRequest) {
+		t.Fatal("expected overflowing duration to be rejected, got", err)
+	}
+
+	// reservations can only be released with their token
+	if err := client.ReleaseOutputs("", reserved); !errors.Is(err, ErrForbidden) {
+		t.Fatal("expected release without token to be forbidden, got", err)
+	} else if err := client.ReleaseOutputs("wrong", reserved); !errors.Is(err, ErrForbidden) {
+		t.Fatal("expected release with wrong token to be forbidden, got", err)
+	} else if res, _ := client.Reservations(); len(res) != 2 {
+		t.Fatal("reservations should not have been released")
+	}
+
+	// funding should only use the unreserved output, and reserve it if asked
+	resp, err := client.FundTransaction(RequestTxnFund{
+		Outputs: []types.SiacoinOutput{{Value: types.NewCurrency64(1)}},
+		Reserve: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.Transaction.SiacoinInputs[0].ParentID != utxos[2].ID {
+		t.Fatal("funding used a reserved output")
+	} else if resp.ReservationToken == "" {
+		t.Fatal("expected a reservation token")
+	}
+	if _, err := client.FundTransaction(RequestTxnFund{
+		Outputs: []types.SiacoinOutput{{Value: types.NewCurrency64(1)}},
+	}); err == nil {
+		t.Fatal("expected insufficient funds after reserving all outputs")
+	}
+
+	// release everything; ProtoWallet should then reserve the outputs it
+	// selects, and release them when discarded
+	if err := client.ReleaseOutputs(token, reserved); err != nil {
+		t.Fatal(err)
+	} else if err := client.ReleaseOutputs(resp.ReservationToken, []types.SiacoinOutputID{utxos[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+	pw := client.ProtoWallet(seed)
+	var txn types.Transaction
+	toSign, discard, err := pw.FundTransaction(&txn, types.SiacoinPrecision.Mul64(2))
+	if err != nil {
+		t.Fatal(err)
+	} else if res, _ := client.Reservations(); len(res) != len(toSign) {
+		t.Fatal("expected ProtoWallet to reserve its inputs")
+	}
+	var txn2 types.Transaction
+	if _, _, err := pw.FundTransaction(&txn2, types.SiacoinPrecision.Mul64(2)); err == nil {
+		t.Fatal("expected ProtoWallet to avoid reserved outputs")
+	}
+	discard()
+	if res, _ := client.Reservations(); len(res) != 0 {
+		t.Fatal("expected discard to release reservations")
+	}
+}
+
+func TestProtoWalletReserveRetries(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	seed := wallet.NewSeed()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(0)),
+	}
+	w.AddAddress(info)
+	cs.sendTxn(types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.SiacoinPrecision}},
+	})
+
+	var attempts int32
+	var status int32
+	ss := NewServer(w, stubTpool{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" && req.URL.Path == "/reservations" {
+			atomic.AddInt32(&attempts, 1)
+			if status := atomic.LoadInt32(&status); status == http.StatusConflict {
+				writeError(rw, http.StatusConflict, CodeOutputReserved, "One or more outputs are already reserved")
+			} else {
+				writeError(rw, int(status), CodeForbidden, "Token lacks the broadcast scope")
+			}
+			return
+		}
+		ss.ServeHTTP(rw, req)
+	}))
+	defer srv.Close()
+	pw := NewClient(srv.URL).ProtoWallet(seed)
+
+	// only reservation conflicts should be retried
+	atomic.StoreInt32(&status, http.StatusForbidden)
+	var txn types.Transaction
+	if _, _, err := pw.FundTransaction(&txn, types.NewCurrency64(1)); !errors.Is(err, ErrForbidden) {
+		t.Fatal("expected forbidden, got", err)
+	} else if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatal("expected 1 attempt, got", n)
+	}
+	atomic.StoreInt32(&attempts, 0)
+	atomic.StoreInt32(&status, http.StatusConflict)
+	if _, _, err := pw.FundTransaction(&txn, types.NewCurrency64(1)); !errors.Is(err, ErrOutputReserved) {
+		t.Fatal("expected output_reserved, got", err)
+	} else if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatal("expected 3 attempts, got", n)
+	}
+}
+
+func TestReservationExpiry(t *testing.T) {
+	rs := newReservationSet()
+	id := types.SiacoinOutputID{1}
+	if _, err := rs.reserve([]types.SiacoinOutputID{id}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	} else if rs.reserved(id) {
+		t.Fatal("expired reservation should not be active")
+	} else if len(rs.list()) != 0 {
+		t.Fatal("expired reservation should be pruned")
+	}
+	token, err := rs.reserve([]types.SiacoinOutputID{id}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := rs.reserve([]types.SiacoinOutputID{id}, time.Now().Add(time.Minute)); err != errOutputReserved {
+		t.Fatal("expected errOutputReserved, got", err)
+	}
+	if err := rs.release("wrong", id); err != errWrongReservationToken {
+		t.Fatal("expected errWrongReservationToken, got", err)
+	} else if err := rs.release(token, id); err != nil {
+		t.Fatal(err)
+	} else if rs.reserved(id) {
+		t.Fatal("released reservation should not be active")
+	}
+}
+
+func TestServerCoinControl(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	seed := wallet.NewSeed()
+	var addrs []types.UnlockHash
+	for i := uint64(0); i < 3; i++ {
+		info := wallet.SeedAddressInfo{
+			UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(i)),
+			KeyIndex:         i,
+		}
+		w.AddAddress(info)
+		addrs = append(addrs, info.UnlockHash())
+	}
+	// outputs of 1, 2, and 3 SC, each in a separate block
+	cs.sendTxn(types.Transaction{}) // genesis block
+	ids := make([]types.SiacoinOutputID, 3)
+	for i, addr := range addrs {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: addr, Value: types.SiacoinPrecision.Mul64(uint64(i + 1))}},
+		}
+		cs.sendTxn(txn)
+		ids[i] = txn.SiacoinOutputID(0)
+	}
+
+	tests := []struct {
+		q   OutputQuery
+		exp []int // in order, if q.Sort is set
+	}{
+		{OutputQuery{Addresses: addrs[1:2]}, []int{1}},
+		{OutputQuery{Addresses: []types.UnlockHash{addrs[0], addrs[2]}}, []int{0, 2}},
+		{OutputQuery{MinValue: types.SiacoinPrecision.Mul64(2)}, []int{1, 2}},
+		{OutputQuery{MaxValue: types.SiacoinPrecision.Mul64(2)}, []int{0, 1}},
+		{OutputQuery{MinConfirmations: 2}, []int{0, 1}},
+		{OutputQuery{MinConfirmations: 3}, []int{0}},
+		{OutputQuery{Sort: SortLargest}, []int{2, 1, 0}},
+		{OutputQuery{Sort: SortSmallest}, []int{0, 1, 2}},
+		{OutputQuery{Sort: SortOldest}, []int{0, 1, 2}},
+		{OutputQuery{Sort: SortNewest, MinValue: types.SiacoinPrecision.Mul64(2)}, []int{2, 1}},
+	}
+	for _, test := range tests {
+		utxos, err := client.QueryOutputs(test.q)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(utxos) != len(test.exp) {
+			t.Errorf("%+v: expected %v outputs, got %v", test.q, len(test.exp), len(utxos))
+			continue
+		}
+		got := make(map[types.SiacoinOutputID]int)
+		for i, o := range utxos {
+			got[o.ID] = i
+		}
+		for i, j := range test.exp {
+			if pos, ok := got[ids[j]]; !ok || (test.q.Sort != "" && pos != i) {
+				t.Errorf("%+v: expected output %v at position %v", test.q, j, i)
+			}
+		}
+	}
+	if _, err := client.QueryOutputs(OutputQuery{Sort: "random"}); err == nil {
+		t.Error("expected invalid sort to be rejected")
+	}
+
+	// funding with coin control
+	fund := func(amount types.Currency, cc CoinControl) (map[types.SiacoinOutputID]bool, error) {
+		resp, err := client.FundTransaction(RequestTxnFund{
+			CoinControl: cc,
+			Outputs:     []types.SiacoinOutput{{Value: amount}},
+		})
+		used := make(map[types.SiacoinOutputID]bool)
+		for _, sci := range resp.Transaction.SiacoinInputs {
+			used[sci.ParentID] = true
+		}
+		return used, err
+	}
+	// included outputs are always spent
+	if used, err := fund(types.NewCurrency64(1), CoinControl{Include: ids[:2]}); err != nil {
+		t.Fatal(err)
+	} else if len(used) != 2 || !used[ids[0]] || !used[ids[1]] {
+		t.Fatal("included outputs were not spent:", used)
+	}
+	// additional outputs are added if necessary...
+	if used, err := fund(types.SiacoinPrecision.Mul64(2), CoinControl{Include: ids[:1]}); err != nil {
+		t.Fatal(err)
+	} else if !used[ids[0]] || len(used) < 2 {
+		t.Fatal("expected additional outputs to be spent:", used)
+	}
+	// ...unless IncludeOnly is set
+	if _, err := fund(types.SiacoinPrecision.Mul64(2), CoinControl{Include: ids[:1], IncludeOnly: true}); err == nil {
+		t.Fatal("expected insufficient funds with IncludeOnly")
+	}
+	// excluded outputs are never spent
+	for i := 0; i < 5; i++ {
+		if used, err := fund(types.NewCurrency64(1), CoinControl{Exclude: ids[1:]}); err != nil {
+			t.Fatal(err)
+		} else if len(used) != 1 || !used[ids[0]] {
+			t.Fatal("excluded outputs were spent:", used)
+		}
+	}
+	if _, err := fund(types.NewCurrency64(1), CoinControl{Include: ids[:1], Exclude: ids[:1]}); err == nil {
+		t.Fatal("expected conflicting coin control to be rejected")
+	}
+
+	// ProtoWallet should respect coin control as well
+	pw := client.ProtoWallet(seed, WithCoinControl(CoinControl{Exclude: ids[:2]}))
+	var txn types.Transaction
+	if _, discard, err := pw.FundTransaction(&txn, types.NewCurrency64(1)); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 || txn.SiacoinInputs[0].ParentID != ids[2] {
+		t.Fatal("ProtoWallet spent an excluded output")
+	} else {
+		discard()
+	}
+	pw = client.ProtoWallet(seed, WithCoinControl(CoinControl{Include: ids[:2]}))
+	txn = types.Transaction{}
+	if _, discard, err := pw.FundTransaction(&txn, types.NewCurrency64(1)); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatal("ProtoWallet did not spend included outputs")
+	} else {
+		discard()
+	}
+}
+
+func TestServerRewardConfirmations(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(w.ConsensusSetSubscriber(store), store.ConsensusChangeID(), nil)
+	client, stop := runServer(NewServer(w, stubTpool{}))
+	defer stop()
+
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	w.AddAddress(info)
+	cs.sendTxn(types.Transaction{}) // genesis block
+
+	// mine a block paying the wallet; for simplicity, the reward is
+	// spendable immediately
+	reward := types.SiacoinOutput{UnlockHash: info.UnlockHash(), Value: types.SiacoinPrecision}
+	b := types.Block{MinerPayouts: []types.SiacoinOutput{reward}}
+	cc := modules.ConsensusChange{
+		AppliedBlocks: []types.Block{b},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{
+			SiacoinOutputDiffs: []modules.SiacoinOutputDiff{{
+				Direction:     modules.DiffApply,
+				SiacoinOutput: reward,
+				ID:            b.MinerPayoutID(0),
+			}},
+			DelayedSiacoinOutputDiffs: []modules.DelayedSiacoinOutputDiff{{
+				Direction:      modules.DiffApply,
+				SiacoinOutput:  reward,
+				ID:             b.MinerPayoutID(0),
+				MaturityHeight: w.ChainHeight() + 1 + types.MaturityDelay,
+			}},
+		},
+	}
+	frand.Read(cc.ID[:])
+	cs.subscriber.ProcessConsensusChange(cc)
+	for i := 0; i < 3; i++ {
+		cs.sendTxn(types.Transaction{ArbitraryData: [][]byte{{byte(i)}}})
+	}
+
+	// the reward's confirmations should be counted from the block that
+	// created it, not from its maturity height
+	if utxos, err := client.QueryOutputs(OutputQuery{MinConfirmations: 4}); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 1 || utxos[0].ID != b.MinerPayoutID(0) {
+		t.Fatal("expected reward to have 4 confirmations:", utxos)
+	}
+	if utxos, err := client.QueryOutputs(OutputQuery{MinConfirmations: 5}); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 0 {
+		t.Fatal("expected reward to have 4 confirmations:", utxos)
+	}
+
+	// outputs created after the first lookup should be found
+	payment := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{UnlockHash: info.UnlockHash(), Value: types.SiacoinPrecision}},
+	}
+	cs.sendTxn(payment)
+	if utxos, err := client.QueryOutputs(OutputQuery{MinConfirmations: 1}); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatal("expected new output to have 1 confirmation:", utxos)
+	} else if utxos, err := client.QueryOutputs(OutputQuery{MinConfirmations: 2}); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 1 || utxos[0].ID != b.MinerPayoutID(0) {
+		t.Fatal("expected new output to have 1 confirmation:", utxos)
+	}
+
+	// outputs of unknown origin should have no confirmations
+	unknown := modules.SiacoinOutputDiff{
+		Direction:     modules.DiffApply,
+		SiacoinOutput: types.SiacoinOutput{UnlockHash: info.UnlockHash(), Value: types.SiacoinPrecision},
+		ID:            types.SiacoinOutputID{1},
+	}
+	cc = modules.ConsensusChange{
+		AppliedBlocks:        []types.Block{{}},
+		ConsensusChangeDiffs: modules.ConsensusChangeDiffs{SiacoinOutputDiffs: []modules.SiacoinOutputDiff{unknown}},
+	}
+	frand.Read(cc.ID[:])
+	cs.subscriber.ProcessConsensusChange(cc)
+	if utxos, err := client.UnspentOutputs(false); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 3 {
+		t.Fatal("expected 3 outputs, got", len(utxos))
+	}
+	if utxos, err := client.QueryOutputs(OutputQuery{MinConfirmations: 1}); err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatal("expected output of unknown origin to be excluded:", utxos)
+	}
+}
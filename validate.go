@@ -0,0 +1,176 @@
+package walrus
+
+import (
+	"fmt"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// signatureErrors are the errors returned by types.Transaction.StandaloneValid
+// that indicate a missing or invalid signature.
+var signatureErrors = map[error]bool{
+	crypto.ErrInvalidSignature:         true,
+	types.ErrEntropyKey:                true,
+	types.ErrFrivolousSignature:        true,
+	types.ErrInvalidPubKeyIndex:        true,
+	types.ErrMissingSignatures:         true,
+	types.ErrPrematureSignature:        true,
+	types.ErrPublicKeyOveruse:          true,
+	types.ErrWholeTransactionViolation: true,
+}
+
+// A validOutput is an output that may be spent by a validated transaction.
+type validOutput struct {
+	types.SiacoinOutput
+	// spentBy is the Limbo transaction that spends the output, if any.
+	spentBy *types.TransactionID
+}
+
+// validateTransactionSet checks txnSet against the wallet's view of the
+// blockchain, without broadcasting it.
+func (s *server) validateTransactionSet(txnSet []types.Transaction) ResponseValidate {
+	// the wallet may not be synced; assume that the Foundation hardfork has
+	// activated, as in addInputs
+	height := s.w.ChainHeight() + 1
+	if height <= types.FoundationHardforkHeight {
+		height = types.FoundationHardforkHeight + 1
+	}
+	minFee, _ := s.tp.FeeEstimation()
+
+	// collect the outputs that the set may spend: the wallet's confirmed
+	// outputs and the outputs created by viable Limbo transactions, noting
+	// which are spent in Limbo
+	outputs := make(map[types.SiacoinOutputID]validOutput)
+	for _, o := range s.w.UnspentOutputs(false) {
+		outputs[o.ID] = validOutput{SiacoinOutput: o.SiacoinOutput}
+	}
+	inSet := make(map[types.TransactionID]struct{}, len(txnSet))
+	for _, txn := range txnSet {
+		inSet[txn.ID()] = struct{}{}
+	}
+	limbo, _ := s.viableLimbo()
+	for _, txn := range limbo {
+		txid := txn.ID()
+		if _, ok := inSet[txid]; ok {
+			continue // the set will supply this transaction
+		}
+		for i, sco := range txn.SiacoinOutputs {
+			outputs[txn.SiacoinOutputID(uint64(i))] = validOutput{SiacoinOutput: sco}
+		}
+	}
+	for _, txn := range limbo {
+		txid := txn.ID()
+		if _, ok := inSet[txid]; ok {
+			continue
+		}
+		for _, sci := range txn.SiacoinInputs {
+			if o, ok := outputs[sci.ParentID]; ok {
+				o.spentBy = &txid
+				outputs[sci.ParentID] = o
+			}
+		}
+	}
+
+	resp := ResponseValidate{
+		Valid:       true,
+		Diagnostics: []Diagnostic{},
+	}
+	var setSize uint64
+	spentInSet := make(map[types.SiacoinOutputID]types.TransactionID)
+	for _, txn := range txnSet {
+		txid := txn.ID()
+		td := TransactionDiagnostics{
+			ID:          txid,
+			Size:        uint64(txn.MarshalSiaSize()),
+			Diagnostics: []Diagnostic{},
+		}
+		for _, fee := range txn.MinerFees {
+			td.Fee = td.Fee.Add(fee)
+		}
+		td.MinFee = minFee.Mul64(td.Size)
+		addDiag := func(sev DiagnosticSeverity, code, msg string, input *int) {
+			td.Diagnostics = append(td.Diagnostics, Diagnostic{
+				Severity: sev,
+				Code:     code,
+				Message:  msg,
+				Input:    input,
+			})
+		}
+
+		if err := txn.StandaloneValid(height); err != nil {
+			code := "invalid_transaction"
+			if signatureErrors[err] {
+				code = "invalid_signature"
+			}
+			addDiag(SeverityError, code, err.Error(), nil)
+		}
+
+		var inputSum types.Currency
+		allKnown := true
+		for i, sci := range txn.SiacoinInputs {
+			i := i
+			if prev, ok := spentInSet[sci.ParentID]; ok {
+				addDiag(SeverityError, "double_spend", fmt.Sprintf("input %v is also spent by transaction %v in the set", sci.ParentID, prev), &i)
+			}
+			spentInSet[sci.ParentID] = txid
+			o, ok := outputs[sci.ParentID]
+			if !ok {
+				allKnown = false
+				if s.w.OwnsAddress(sci.UnlockConditions.UnlockHash()) {
+					addDiag(SeverityError, "missing_input", fmt.Sprintf("input %v does not exist or has already been spent", sci.ParentID), &i)
+				} else {
+					addDiag(SeverityWarning, "unknown_input", fmt.Sprintf("input %v is not tracked by the wallet and could not be checked", sci.ParentID), &i)
+				}
+				continue
+			}
+			inputSum = inputSum.Add(o.Value)
+			if o.spentBy != nil {
+				addDiag(SeverityError, "limbo_conflict", fmt.Sprintf("input %v is already spent by Limbo transaction %v", sci.ParentID, *o.spentBy), &i)
+			}
+			if sci.UnlockConditions.UnlockHash() != o.UnlockHash {
+				addDiag(SeverityError, "wrong_unlock_conditions", fmt.Sprintf("unlock conditions of input %v do not match its parent output", sci.ParentID), &i)
+			}
+		}
+
+		var outputSum types.Currency
+		for _, sco := range txn.SiacoinOutputs {
+			outputSum = outputSum.Add(sco.Value)
+		}
+		for _, fc := range txn.FileContracts {
+			outputSum = outputSum.Add(fc.Payout)
+		}
+		if allKnown && len(txn.SiacoinInputs) > 0 && !inputSum.Equals(outputSum.Add(td.Fee)) {
+			addDiag(SeverityError, "value_mismatch", fmt.Sprintf("inputs total %v H, but outputs and fees total %v H", inputSum, outputSum.Add(td.Fee)), nil)
+		}
+		if td.Fee.Cmp(td.MinFee) < 0 {
+			addDiag(SeverityWarning, "low_fee", fmt.Sprintf("fee of %v H is below the recommended %v H", td.Fee, td.MinFee), nil)
+		}
+
+		// outputs created by this transaction may be spent by later ones
+		for i, sco := range txn.SiacoinOutputs {
+			outputs[txn.SiacoinOutputID(uint64(i))] = validOutput{SiacoinOutput: sco}
+		}
+
+		td.Valid = true
+		for _, d := range td.Diagnostics {
+			td.Valid = td.Valid && d.Severity != SeverityError
+		}
+		resp.Valid = resp.Valid && td.Valid
+		resp.Fee = resp.Fee.Add(td.Fee)
+		setSize += td.Size
+		resp.Transactions = append(resp.Transactions, td)
+	}
+
+	// the transaction pool considers the fee of the set as a whole
+	resp.MinFee = minFee.Mul64(setSize)
+	if resp.Fee.Cmp(resp.MinFee) < 0 {
+		resp.Valid = false
+		resp.Diagnostics = append(resp.Diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Code:     "insufficient_fee",
+			Message:  fmt.Sprintf("set fee of %v H is below the minimum of %v H", resp.Fee, resp.MinFee),
+		})
+	}
+	return resp
+}
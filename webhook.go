@@ -0,0 +1,337 @@
+package walrus
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/types"
+)
+
+// WebhookSignatureHeader is the HTTP header containing the signature of a
+// webhook payload. See VerifyWebhookSignature.
+const WebhookSignatureHeader = "Walrus-Signature"
+
+// WebhookOptions configures a WebhookNotifier.
+type WebhookOptions struct {
+	// URL is the URL that payloads are POSTed to.
+	URL string
+	// Secret is the key used to sign payloads.
+	Secret string
+	// Addresses restricts notifications to payments received by the
+	// specified addresses. If empty, payments to any wallet address trigger a
+	// notification.
+	Addresses []types.UnlockHash
+	// QueuePath is the path of the file storing pending deliveries. If empty,
+	// the queue is not persisted.
+	QueuePath string
+	// MaxAttempts is the number of delivery attempts made before a payload is
+	// discarded. The default is 20.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry; each subsequent
+	// retry doubles the delay, up to MaxBackoff. The defaults are 5 seconds
+	// and 1 hour, respectively.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Client is the HTTP client used for deliveries. The default client times
+	// out after 30 seconds.
+	Client *http.Client
+}
+
+// A WebhookPayload is the JSON body POSTed to a webhook URL when a wallet
+// address receives siacoins.
+type WebhookPayload struct {
+	// ID uniquely identifies the payment; it is identical across retries, and
+	// thus can be used to deduplicate deliveries.
+	ID            string              `json:"id"`
+	TransactionID types.TransactionID `json:"transactionID"`
+	Address       types.UnlockHash    `json:"address"`
+	Amount        types.Currency      `json:"amount"`
+	BlockID       types.BlockID       `json:"blockID"`
+	BlockHeight   types.BlockHeight   `json:"blockHeight"`
+	Timestamp     time.Time           `json:"timestamp"`
+}
+
+// SignWebhookPayload returns the value of the WebhookSignatureHeader for the
+// supplied payload body.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether sig, the value of the
+// WebhookSignatureHeader, is a valid signature of body.
+func VerifyWebhookSignature(secret string, body []byte, sig string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, body)), []byte(sig))
+}
+
+type webhookDelivery struct {
+	Payload     WebhookPayload `json:"payload"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"nextAttempt"`
+}
+
+// A WebhookNotifier POSTs a signed WebhookPayload to a URL whenever a wallet
+// address receives siacoins in a confirmed transaction. Transactions that
+// spend wallet outputs (i.e. outgoing payments and their change) do not
+// trigger notifications. Failed deliveries are retried with exponential
+// backoff.
+type WebhookNotifier struct {
+	opts   WebhookOptions
+	addrs  map[types.UnlockHash]struct{}
+	hub    *EventHub
+	unsub  func()
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	done   chan struct{}
+
+	mu    sync.Mutex
+	queue []webhookDelivery
+	err   error
+}
+
+func (n *WebhookNotifier) watching(addr types.UnlockHash) bool {
+	if len(n.addrs) > 0 {
+		_, ok := n.addrs[addr]
+		return ok
+	}
+	return n.hub.w.OwnsAddress(addr)
+}
+
+// processEvents enqueues payloads for any incoming payments in events.
+func (n *WebhookNotifier) processEvents(events []Event) {
+	if n.ctx.Err() != nil {
+		return // closed
+	}
+	var payloads []WebhookPayload
+	for _, e := range events {
+		if e.Type != EventTransactionConfirmed || !e.Transaction.Debit.IsZero() {
+			continue
+		}
+		txn := e.Transaction
+		amounts := make(map[types.UnlockHash]types.Currency)
+		var addrs []types.UnlockHash // preserve output order
+		for _, sco := range txn.Transaction.SiacoinOutputs {
+			if !n.watching(sco.UnlockHash) {
+				continue
+			}
+			if _, ok := amounts[sco.UnlockHash]; !ok {
+				addrs = append(addrs, sco.UnlockHash)
+			}
+			amounts[sco.UnlockHash] = amounts[sco.UnlockHash].Add(sco.Value)
+		}
+		for _, addr := range addrs {
+			payloads = append(payloads, WebhookPayload{
+				ID:            crypto.HashAll(*e.TransactionID, addr).String(),
+				TransactionID: *e.TransactionID,
+				Address:       addr,
+				Amount:        amounts[addr],
+				BlockID:       txn.BlockID,
+				BlockHeight:   txn.BlockHeight,
+				Timestamp:     txn.Timestamp,
+			})
+		}
+	}
+	if len(payloads) == 0 {
+		return
+	}
+	n.mu.Lock()
+	for _, p := range payloads {
+		n.queue = append(n.queue, webhookDelivery{
+			Payload:     p,
+			NextAttempt: time.Now(),
+		})
+	}
+	n.saveQueue()
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveQueue persists the queue. n.mu must be held.
+func (n *WebhookNotifier) saveQueue() {
+	if n.opts.QueuePath == "" {
+		return
+	}
+	if err := saveJSON(n.opts.QueuePath, n.queue); err != nil {
+		n.err = err
+	}
+}
+
+// Err returns the most recent error encountered while persisting the queue,
+// if any.
+func (n *WebhookNotifier) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// Pending returns the number of payloads awaiting delivery.
+func (n *WebhookNotifier) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queue)
+}
+
+func (n *WebhookNotifier) deliver(p WebhookPayload) error {
+	body, _ := json.Marshal(p)
+	req, err := http.NewRequest("POST", n.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(n.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(n.opts.Secret, body))
+	resp, err := n.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// deliverDue attempts to deliver every payload whose next attempt is due,
+// returning the time at which the next attempt will be due, or the zero time
+// if the queue is empty.
+func (n *WebhookNotifier) deliverDue() time.Time {
+	for n.ctx.Err() == nil {
+		n.mu.Lock()
+		var d *webhookDelivery
+		for i := range n.queue {
+			if !n.queue[i].NextAttempt.After(time.Now()) {
+				d = &n.queue[i]
+				break
+			}
+		}
+		if d == nil {
+			var next time.Time
+			for _, d := range n.queue {
+				if next.IsZero() || d.NextAttempt.Before(next) {
+					next = d.NextAttempt
+				}
+			}
+			n.mu.Unlock()
+			return next
+		}
+		p := d.Payload
+		n.mu.Unlock()
+
+		err := n.deliver(p)
+		if n.ctx.Err() != nil {
+			break // don't penalize deliveries interrupted by Close
+		}
+
+		n.mu.Lock()
+		for i := range n.queue {
+			if n.queue[i].Payload.ID != p.ID {
+				continue
+			}
+			if d := &n.queue[i]; err == nil || d.Attempts+1 >= n.opts.MaxAttempts {
+				n.queue = append(n.queue[:i], n.queue[i+1:]...)
+			} else {
+				d.Attempts++
+				backoff := n.opts.InitialBackoff << uint(d.Attempts-1)
+				if backoff > n.opts.MaxBackoff || backoff <= 0 {
+					backoff = n.opts.MaxBackoff
+				}
+				d.NextAttempt = time.Now().Add(backoff)
+			}
+			break
+		}
+		n.saveQueue()
+		n.mu.Unlock()
+	}
+	return time.Time{}
+}
+
+func (n *WebhookNotifier) run() {
+	defer close(n.done)
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-n.ctx.Done():
+			timer.Stop()
+			return
+		case <-n.wake:
+		case <-timer.C:
+		}
+		next := n.deliverDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// Close stops the notifier. Pending deliveries remain in the queue, and will
+// be resumed by a new notifier using the same QueuePath.
+func (n *WebhookNotifier) Close() error {
+	n.unsub()
+	n.cancel()
+	<-n.done
+	return nil
+}
+
+// NewWebhookNotifier returns a WebhookNotifier that sends notifications for
+// payments reported by the supplied EventHub. If opts.QueuePath refers to an
+// existing queue, its pending deliveries are resumed.
+func NewWebhookNotifier(hub *EventHub, opts WebhookOptions) (*WebhookNotifier, error) {
+	if !strings.HasPrefix(opts.URL, "http://") && !strings.HasPrefix(opts.URL, "https://") {
+		return nil, errors.New("webhook URL must begin with http:// or https://")
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 20
+	}
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = 5 * time.Second
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	n := &WebhookNotifier{
+		opts:  opts,
+		addrs: make(map[types.UnlockHash]struct{}),
+		hub:   hub,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	for _, addr := range opts.Addresses {
+		n.addrs[addr] = struct{}{}
+	}
+	if opts.QueuePath != "" {
+		if err := loadJSON(opts.QueuePath, &n.queue); err != nil {
+			return nil, err
+		}
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.unsub = hub.listen(n.processEvents)
+	go n.run()
+	return n, nil
+}
@@ -0,0 +1,119 @@
+package walrus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+	"lukechampine.com/us/wallet"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	store := wallet.NewEphemeralStore()
+	w := wallet.New(store)
+	hub, err := NewEventHub(w, EventHubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := new(mockCS)
+	cs.ConsensusSetSubscribe(hub.ConsensusSetSubscriber(w.ConsensusSetSubscriber(store)), store.ConsensusChangeID(), nil)
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(wallet.NewSeed().PublicKey(0)),
+	}
+	addr := info.UnlockHash()
+	w.AddAddress(info)
+
+	// start a listener that rejects deliveries to /down; since the first
+	// notifier uses that URL, a request that it had in flight when it was
+	// closed cannot be mistaken for a delivery by its successor
+	var mu sync.Mutex
+	var received []WebhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(req.Body)
+		if !VerifyWebhookSignature("foo", body, req.Header.Get(WebhookSignatureHeader)) {
+			t.Error("invalid signature")
+		}
+		if req.URL.Path == "/down" {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var p WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Error(err)
+		}
+		received = append(received, p)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queuePath := filepath.Join(dir, "webhooks.json")
+	opts := WebhookOptions{
+		URL:            srv.URL + "/down",
+		Secret:         "foo",
+		QueuePath:      queuePath,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+	wn, err := NewWebhookNotifier(hub, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// receive a payment split across two outputs, plus an output to an
+	// unrelated address
+	payment := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{UnlockHash: addr, Value: types.SiacoinPrecision},
+			{UnlockHash: types.UnlockHash{1}, Value: types.SiacoinPrecision},
+			{UnlockHash: addr, Value: types.SiacoinPrecision},
+		},
+	}
+	cs.sendTxn(payment)
+	if wn.Pending() != 1 {
+		t.Fatal("expected 1 pending delivery, got", wn.Pending())
+	}
+
+	// let a few deliveries fail, then restart the notifier; the delivery
+	// should survive
+	time.Sleep(50 * time.Millisecond)
+	wn.Close()
+	opts.URL = srv.URL + "/up"
+	wn, err = NewWebhookNotifier(hub, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wn.Close()
+	if len(hub.listeners) != 1 {
+		t.Fatal("closed notifier should not receive events")
+	}
+	for start := time.Now(); wn.Pending() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("delivery did not succeed")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatal("expected 1 delivery, got", len(received))
+	}
+	p := received[0]
+	if p.TransactionID != payment.ID() || p.Address != addr || !p.Amount.Equals(types.SiacoinPrecision.Mul64(2)) {
+		t.Fatal("wrong payload:", p)
+	} else if p.ID == "" {
+		t.Fatal("payload should have an ID")
+	}
+}